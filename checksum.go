@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"fmt"
+	"hash"
+)
+
+// hashingWriter computes the sha256 and size of the data written to the
+// resumable writer, the hash state is kept at the offset the writer can
+// resume from so the hash can be resumed with the write
+type hashingWriter struct {
+	ResumableWriter
+	hash    hash.Hash
+	offset  int64
+	pending []byte // written data after the offset
+}
+
+// newHashingWriter returns the hashing writer of the resumable writer that
+// continues from the hash state at the offset, the writer is rewound to the
+// start when the hash can't be resumed
+func newHashingWriter(w ResumableWriter, offset int64, state []byte) (*hashingWriter, error) {
+	hw := &hashingWriter{ResumableWriter: w, hash: sha256.New()}
+
+	actual, err := w.Rewind(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	if actual > 0 && actual == offset && len(state) > 0 {
+		if err := hw.hash.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err == nil {
+			hw.offset = actual
+			return hw, nil
+		}
+	}
+
+	hw.hash.Reset()
+	if _, err := w.Rewind(0); err != nil {
+		return nil, err
+	}
+
+	return hw, nil
+}
+
+func (w *hashingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResumableWriter.Write(p)
+	w.pending = append(w.pending, p[:n]...)
+
+	if offset := w.ResumableWriter.Offset(); offset > w.offset {
+		confirmed := min(offset-w.offset, int64(len(w.pending)))
+		w.hash.Write(w.pending[:confirmed])
+		w.pending = append(w.pending[:0], w.pending[confirmed:]...)
+		w.offset += confirmed
+	}
+
+	return n, err
+}
+
+// Rewind rewinds the writer, the hash is only kept when the writer
+// continues from the offset of the hash state
+func (w *hashingWriter) Rewind(offset int64) (int64, error) {
+	actual, err := w.ResumableWriter.Rewind(offset)
+	if err != nil {
+		return 0, err
+	}
+
+	w.pending = w.pending[:0]
+	if actual == w.offset {
+		return actual, nil
+	}
+
+	w.hash.Reset()
+	w.offset = 0
+
+	return w.ResumableWriter.Rewind(0)
+}
+
+// Offset returns the offset of the hash state
+func (w *hashingWriter) Offset() int64 {
+	return w.offset
+}
+
+// HashState returns the hash state at the offset
+func (w *hashingWriter) HashState() []byte {
+	state, err := w.hash.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil
+	}
+
+	return state
+}
+
+// Size returns the amount of bytes written
+func (w *hashingWriter) Size() int64 {
+	return w.offset + int64(len(w.pending))
+}
+
+// Sum returns the hex encoded sha256 of the written data
+func (w *hashingWriter) Sum() string {
+	sum := sha256.New()
+	if state, err := w.hash.(encoding.BinaryMarshaler).MarshalBinary(); err == nil {
+		sum.(encoding.BinaryUnmarshaler).UnmarshalBinary(state) //nolint: errcheck
+	}
+
+	sum.Write(w.pending)
+
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// checkSize returns an error when zoom reports a different size
+func checkSize(rec RecordingFile, size int64) error {
+	if rec.FileSize > 0 && size != rec.FileSize {
+		return fmt.Errorf("%s recording %s has size %d but zoom reports %d", rec.RecordingType, rec.ID, size, rec.FileSize)
+	}
+
+	return nil
+}
+
+// hashWriter is a FileWriter that computes the sha256 and size of the data
+type hashWriter struct {
+	FileWriter
+	hash hash.Hash
+	size int64
+}
+
+func newHashWriter(w FileWriter) *hashWriter {
+	return &hashWriter{FileWriter: w, hash: sha256.New()}
+}
+
+func (w *hashWriter) Write(p []byte) (int, error) {
+	n, err := w.FileWriter.Write(p)
+	w.hash.Write(p[:n])
+	w.size += int64(n)
+
+	return n, err
+}
+
+// Sum returns the hex encoded sha256 of the written data
+func (w *hashWriter) Sum() string {
+	return hex.EncodeToString(w.hash.Sum(nil))
+}
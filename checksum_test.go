@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+// blockWriter is a resumable writer that only keeps whole blocks, like the
+// parts of a multipart upload
+type blockWriter struct {
+	data      []byte
+	blockSize int
+}
+
+func (w *blockWriter) Write(p []byte) (int, error) {
+	w.data = append(w.data, p...)
+	return len(p), nil
+}
+
+func (w *blockWriter) Offset() int64 {
+	return int64(len(w.data) / w.blockSize * w.blockSize)
+}
+
+func (w *blockWriter) Rewind(offset int64) (int64, error) {
+	offset = min(offset, w.Offset()) / int64(w.blockSize) * int64(w.blockSize)
+	w.data = w.data[:offset]
+
+	return offset, nil
+}
+
+func (w *blockWriter) State() string { return "" }
+func (w *blockWriter) Pause() error  { return nil }
+func (w *blockWriter) Abort() error  { return nil }
+func (w *blockWriter) Close() error  { return nil }
+
+func TestHashingWriterResume(t *testing.T) {
+	content := []byte("some random file")
+	expected := fmt.Sprintf("%x", sha256.Sum256(content))
+
+	bw := &blockWriter{blockSize: 4}
+	hw, err := newHashingWriter(bw, 0, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 9; i += 3 {
+		_, _ = hw.Write(content[i : i+3]) //nolint: errcheck
+	}
+
+	assert(t, hw.Offset() == 8, "hash offset must follow the offset of the writer")
+	offset, state := hw.Offset(), hw.HashState()
+
+	hw, err = newHashingWriter(bw, offset, state)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assert(t, hw.Offset() == 8, "hash must be resumed from the offset")
+
+	_, _ = hw.Write(content[8:]) //nolint: errcheck
+	assert(t, string(bw.data) == string(content), "writer must contain the whole content")
+	assert(t, hw.Sum() == expected, "resumed hash must be the hash of the whole content")
+	assert(t, hw.Size() == int64(len(content)), "size must be the size of the whole content")
+
+	// the writer lost data after the hash state
+	bw.data = bw.data[:4]
+	hw, err = newHashingWriter(bw, offset, state)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assert(t, hw.Offset() == 0 && len(bw.data) == 0, "writer must restart when the hash can't be resumed")
+}
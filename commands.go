@@ -0,0 +1,770 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage: zoomdl [-config path] <command> [arguments]
+
+commands:
+  run                       sweep the recordings on every interval (default)
+  sweep [-once] [-dry-run]  sweep the recordings, only once with -once
+                            or only show the plan with -dry-run [-json]
+  list [-from date]         show the remote recordings and if they are archived
+  status                    summarise the saved records
+  download <meeting-uuid>   download the recordings of a single meeting
+  verify [-quick] [-json]   check the saved records against the destinations,
+                            only the existence and size with -quick
+  repair [-dry-run] [-json] copy the files that are missing in a destination
+                            from a destination that has them
+  config validate           validate the configuration
+  filters test [-id id] [-host email] [-user id] <topic>
+                            show if a meeting is swept and which rule matches
+
+every command accepts -config <path> and -account <name>
+`
+
+// runCommand runs the command of the given arguments and returns the exit code
+func runCommand(args []string, stdout io.Writer) int {
+	global := flag.NewFlagSet("zoomdl", flag.ContinueOnError)
+	configPath := global.String("config", os.Getenv("ZOOMDL_CONFIG"), "path to the yaml config file")
+	global.Usage = func() { fmt.Fprint(global.Output(), usage) }
+
+	if err := global.Parse(args); err != nil {
+		return exitCode(err)
+	}
+
+	name, args := "run", global.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+
+	cmd := &command{name: name, configPath: *configPath, stdout: stdout}
+
+	switch name {
+	case "run":
+		return cmd.run(args)
+	case "sweep":
+		return cmd.sweep(args)
+	case "list":
+		return cmd.list(args)
+	case "status":
+		return cmd.status(args)
+	case "download":
+		return cmd.download(args)
+	case "verify":
+		return cmd.verify(args)
+	case "repair":
+		return cmd.repair(args)
+	case "config":
+		return cmd.config(args)
+	case "filters":
+		return cmd.filters(args)
+	case "help", "-h", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command '%s'\n\n%s", name, usage)
+		return 2
+	}
+}
+
+// command contains the shared state and flags of the subcommands
+type command struct {
+	name       string
+	configPath string
+	account    string
+	stdout     io.Writer
+
+	// serve sweeps the recordings of an account on every interval, run
+	// unless it's replaced in the tests
+	serve func(*ZoomClient)
+}
+
+func exitCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+
+	return 2
+}
+
+// flags returns the flagset of the command with the shared flags
+func (c *command) flags(argsUsage string) *flag.FlagSet {
+	fset := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fset.StringVar(&c.configPath, "config", c.configPath, "path to the yaml config file")
+	fset.StringVar(&c.account, "account", "", "only use the account with this name")
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "usage: zoomdl %s [flags] %s\n", c.name, argsUsage)
+		fset.PrintDefaults()
+	}
+
+	return fset
+}
+
+// configs returns the config of every (selected) account
+func (c *command) configs() ([]*Config, error) {
+	configs, err := c.accounts()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.account != "" {
+		configs = slices.DeleteFunc(configs, func(cfg *Config) bool {
+			return cfg.Name != c.account
+		})
+
+		if len(configs) == 0 {
+			return nil, fmt.Errorf("unknown account '%s'", c.account)
+		}
+	}
+
+	return configs, nil
+}
+
+// accounts returns the config of every account, regardless of the
+// selected account
+func (c *command) accounts() ([]*Config, error) {
+	config, err := LoadConfig(c.configPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	if len(config.Accounts) == 0 {
+		return []*Config{config}, nil
+	}
+
+	return config.Accounts, nil
+}
+
+// clients returns a zoom client for every (selected) account
+func (c *command) clients() ([]*ZoomClient, error) {
+	configs, err := c.configs()
+	if err != nil {
+		return nil, err
+	}
+
+	return newClients(configs)
+}
+
+// newClients returns a zoom client for every config
+func newClients(configs []*Config) ([]*ZoomClient, error) {
+	clients := make([]*ZoomClient, 0, len(configs))
+	for _, cfg := range configs {
+		fs, err := newMultiFS(context.Background(), cfg)
+		if err != nil {
+			return nil, fmt.Errorf("error opening destinations of %s: %w", cmp.Or(cfg.Name, "default account"), err)
+		}
+
+		routes, err := openRoutes(context.Background(), cfg)
+		if err != nil {
+			return nil, fmt.Errorf("error opening routes of %s: %w", cmp.Or(cfg.Name, "default account"), err)
+		}
+
+		clients = append(clients, NewZoomClient(cfg, fs, routes...))
+	}
+
+	return clients, nil
+}
+
+func (c *command) fail(err error) int {
+	fmt.Fprintf(os.Stderr, "%s: %v\n", c.name, err)
+	return 1
+}
+
+func (c *command) run(args []string) int {
+	fset := c.flags("")
+	if err := fset.Parse(args); err != nil {
+		return exitCode(err)
+	}
+
+	clients, err := c.clients()
+	if err != nil {
+		return c.fail(err)
+	}
+
+	return c.daemon(clients)
+}
+
+// daemon sweeps the recordings of the clients on every interval until the
+// process is stopped
+func (c *command) daemon(clients []*ZoomClient) int {
+	serve := c.serve
+	if serve == nil {
+		serve = run
+	}
+
+	var wg sync.WaitGroup
+	for _, zc := range clients {
+		wg.Go(func() {
+			serve(zc)
+		})
+	}
+
+	wg.Wait()
+	return 0
+}
+
+func (c *command) sweep(args []string) int {
+	fset := c.flags("")
+	once := fset.Bool("once", false, "sweep once and exit with a non zero code on errors")
+	dryRun := fset.Bool("dry-run", false, "only show what would be downloaded and deleted")
+	asJSON := fset.Bool("json", false, "write the dry run plan as json")
+	if err := fset.Parse(args); err != nil {
+		return exitCode(err)
+	}
+
+	if *dryRun {
+		return c.dryRun(*asJSON)
+	}
+
+	clients, err := c.clients()
+	if err != nil {
+		return c.fail(err)
+	}
+
+	if !*once {
+		return c.daemon(clients)
+	}
+
+	var errs error
+	for _, zc := range clients {
+		if err := zc.Sweep(); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
+
+	if errs != nil {
+		return c.fail(errs)
+	}
+
+	return 0
+}
+
+// dryRun writes the sweep plan of every account
+func (c *command) dryRun(asJSON bool) int {
+	clients, err := c.clients()
+	if err != nil {
+		return c.fail(err)
+	}
+
+	plans := make([]*SweepPlan, 0, len(clients))
+
+	var errs error
+	for _, zc := range clients {
+		plan, err := zc.Plan()
+		if err != nil {
+			errs = errors.Join(errs, err)
+		}
+
+		if plan != nil {
+			plans = append(plans, plan)
+		}
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(plans); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	} else {
+		for _, plan := range plans {
+			writePlan(c.stdout, plan)
+		}
+	}
+
+	if errs != nil {
+		return c.fail(errs)
+	}
+
+	return 0
+}
+
+// writePlan writes the sweep plan in a human readable form
+func writePlan(w io.Writer, plan *SweepPlan) {
+	if plan.Account != "" {
+		fmt.Fprintf(w, "account %s:\n", plan.Account)
+	}
+
+	fmt.Fprintf(w, "%d recording files would be downloaded\n", len(plan.Downloads))
+	for _, dl := range plan.Downloads {
+		fmt.Fprintf(w, "  download %s (%s of '%s')\n", dl.Target, dl.File.RecordingType, dl.Topic)
+		if dl.Route != "" {
+			fmt.Fprintf(w, "    routed by '%s'\n", dl.Route)
+		}
+	}
+
+	fmt.Fprintf(w, "%d meetings would be deleted from zoom once their files are verified\n", len(plan.Deletions))
+	for _, del := range plan.Deletions {
+		if plan.DeleteMode != "file" {
+			fmt.Fprintf(w, "  delete meeting %d '%s'\n", del.MeetingID, del.Topic)
+			continue
+		}
+
+		for _, rf := range del.Files {
+			fmt.Fprintf(w, "  delete %s of meeting %d '%s'\n", rf.RecordingType, del.MeetingID, del.Topic)
+		}
+	}
+
+	if len(plan.Retained) > 0 {
+		fmt.Fprintf(w, "%d meetings would be kept in zoom\n", len(plan.Retained))
+		for _, ret := range plan.Retained {
+			fmt.Fprintf(w, "  keep meeting %d '%s': %s\n", ret.MeetingID, ret.Topic, ret.Reason)
+		}
+	}
+
+	fmt.Fprintln(w)
+}
+
+func (c *command) list(args []string) int {
+	fset := c.flags("")
+	fromStr := fset.String("from", "", "only list recordings from this date (YYYY-MM-DD)")
+	if err := fset.Parse(args); err != nil {
+		return exitCode(err)
+	}
+
+	var from time.Time
+	if *fromStr != "" {
+		var err error
+		if from, err = time.Parse(time.DateOnly, *fromStr); err != nil {
+			return c.fail(fmt.Errorf("invalid from date: %w", err))
+		}
+	}
+
+	clients, err := c.clients()
+	if err != nil {
+		return c.fail(err)
+	}
+
+	wr := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(wr, "ACCOUNT\tUSER\tMEETING\tTOPIC\tRECORDED AT\tTYPE\tARCHIVED")
+
+	var errs error
+	for _, zc := range clients {
+		errs = errors.Join(errs, listRecordings(wr, zc, from))
+	}
+
+	wr.Flush() //nolint: errcheck
+
+	if errs != nil {
+		return c.fail(errs)
+	}
+
+	return 0
+}
+
+// listRecordings writes the recording files of the account in zoom and
+// whether they're archived
+func listRecordings(wr io.Writer, zc *ZoomClient, from time.Time) (err error) {
+	state, err := zc.openReadOnlyState(zc.context)
+	if err != nil {
+		return err
+	}
+
+	defer zc.closeState(state, &err)
+
+	users, err := zc.SweepUsers()
+	if err != nil {
+		return err
+	}
+
+	var errs error
+	for _, user := range users {
+		meetings, err := zc.ListUserRecordings(cmp.Or(user.ID, "me"), from)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+
+		for _, meeting := range meetings {
+			for _, rf := range meeting.RecordingFiles {
+				_, saved, err := state.Record(zc.context, rf.ID)
+				if err != nil {
+					return err
+				}
+
+				archived := "no"
+				if rf.ID != "" && saved {
+					archived = "yes"
+				}
+
+				fmt.Fprintf(wr, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+					cmp.Or(zc.config.Name, "-"),
+					cmp.Or(user.Email, meeting.HostEmail, "me"),
+					meeting.UUID,
+					meeting.Topic,
+					rf.RecordingStart.Format(time.DateTime),
+					rf.RecordingType,
+					archived,
+				)
+			}
+		}
+	}
+
+	return errs
+}
+
+func (c *command) status(args []string) int {
+	fset := c.flags("")
+	if err := fset.Parse(args); err != nil {
+		return exitCode(err)
+	}
+
+	clients, err := c.clients()
+	if err != nil {
+		return c.fail(err)
+	}
+
+	var errs error
+	for _, zc := range clients {
+		errs = errors.Join(errs, writeStatus(c.stdout, zc))
+	}
+
+	if errs != nil {
+		return c.fail(errs)
+	}
+
+	return 0
+}
+
+// writeStatus writes the summary of the saved records
+func writeStatus(w io.Writer, zc *ZoomClient) (err error) {
+	state, err := zc.openReadOnlyState(zc.context)
+	if err != nil {
+		return err
+	}
+
+	defer zc.closeState(state, &err)
+
+	records, err := state.Records(zc.context)
+	if err != nil {
+		return err
+	}
+
+	meetings, users := map[string]bool{}, map[string]bool{}
+	var first, last, lastSaved time.Time
+
+	for _, rec := range records {
+		meetings[rec.SessionID] = true
+		users[rec.UserEmail] = true
+
+		if first.IsZero() || rec.RecordedAt.Before(first) {
+			first = rec.RecordedAt
+		}
+
+		last = latest(last, rec.RecordedAt)
+		lastSaved = latest(lastSaved, rec.SavedAt)
+	}
+
+	wr := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if zc.config.Name != "" {
+		fmt.Fprintf(wr, "account:\t%s\n", zc.config.Name)
+	}
+
+	if zc.config.StateDB != "" {
+		fmt.Fprintf(wr, "state database:\t%s\n", zc.config.StateDB)
+	} else {
+		fmt.Fprintf(wr, "records file:\t%s\n", zc.recordsFile())
+	}
+
+	fmt.Fprintf(wr, "recording files:\t%d\n", len(records))
+	fmt.Fprintf(wr, "meetings:\t%d\n", len(meetings))
+	if zc.config.AllUsers {
+		fmt.Fprintf(wr, "users:\t%d\n", len(users))
+	}
+
+	if len(records) > 0 {
+		fmt.Fprintf(wr, "first recording:\t%s\n", first.Format(time.DateTime))
+		fmt.Fprintf(wr, "last recording:\t%s\n", last.Format(time.DateTime))
+		fmt.Fprintf(wr, "last saved:\t%s\n", lastSaved.Format(time.DateTime))
+	}
+
+	fmt.Fprintln(wr)
+
+	return wr.Flush()
+}
+
+func (c *command) download(args []string) int {
+	fset := c.flags("<meeting-uuid>")
+	if err := fset.Parse(args); err != nil {
+		return exitCode(err)
+	}
+
+	if fset.NArg() != 1 {
+		fset.Usage()
+		return 2
+	}
+
+	clients, err := c.clients()
+	if err != nil {
+		return c.fail(err)
+	}
+
+	if len(clients) > 1 {
+		return c.fail(errors.New("multiple accounts configured, select one with -account"))
+	}
+
+	if err := clients[0].DownloadMeeting(fset.Arg(0)); err != nil {
+		return c.fail(err)
+	}
+
+	return 0
+}
+
+func (c *command) verify(args []string) int {
+	fset := c.flags("")
+	quick := fset.Bool("quick", false, "only check the existence and size of the files")
+	asJSON := fset.Bool("json", false, "write the reports as json")
+	if err := fset.Parse(args); err != nil {
+		return exitCode(err)
+	}
+
+	clients, err := c.clients()
+	if err != nil {
+		return c.fail(err)
+	}
+
+	// the accounts can share destinations, their files aren't orphaned
+	accounts, err := c.accounts()
+	if err != nil {
+		return c.fail(err)
+	}
+
+	all, err := newClients(accounts)
+	if err != nil {
+		return c.fail(err)
+	}
+
+	reports := make([]*VerifyReport, 0, len(clients))
+	ok := true
+
+	var errs error
+	for _, zc := range clients {
+		others := slices.DeleteFunc(slices.Clone(all), func(other *ZoomClient) bool {
+			return other.config.Name == zc.config.Name
+		})
+
+		report, err := zc.Verify(*quick, others...)
+		if err != nil {
+			errs = errors.Join(errs, err)
+		}
+
+		if report != nil {
+			reports = append(reports, report)
+			ok = ok && report.OK()
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(reports); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	} else {
+		for _, report := range reports {
+			writeVerifyReport(c.stdout, report)
+		}
+	}
+
+	if errs != nil {
+		return c.fail(errs)
+	}
+
+	if !ok {
+		return 1
+	}
+
+	return 0
+}
+
+// writeVerifyReport writes the verify report in a human readable form
+func writeVerifyReport(w io.Writer, report *VerifyReport) {
+	if report.Account != "" {
+		fmt.Fprintf(w, "account %s:\n", report.Account)
+	}
+
+	for _, dst := range report.Destinations {
+		fmt.Fprintf(w, "%s: %d files checked, %d missing, %d corrupted, %d orphaned\n",
+			dst.Destination, dst.Checked, len(dst.Missing), len(dst.Corrupted), len(dst.Orphaned))
+
+		for _, p := range dst.Missing {
+			fmt.Fprintf(w, "  missing %s\n", p)
+		}
+
+		for _, file := range dst.Corrupted {
+			fmt.Fprintf(w, "  corrupted %s: %s\n", file.Path, file.Reason)
+		}
+
+		for _, p := range dst.Orphaned {
+			fmt.Fprintf(w, "  orphaned %s\n", p)
+		}
+	}
+
+	fmt.Fprintln(w)
+}
+
+func (c *command) repair(args []string) int {
+	fset := c.flags("")
+	dryRun := fset.Bool("dry-run", false, "only show the files that would be copied")
+	asJSON := fset.Bool("json", false, "write the reports as json")
+	if err := fset.Parse(args); err != nil {
+		return exitCode(err)
+	}
+
+	clients, err := c.clients()
+	if err != nil {
+		return c.fail(err)
+	}
+
+	reports := make([]*RepairReport, 0, len(clients))
+	failed := false
+
+	var errs error
+	for _, zc := range clients {
+		report, err := zc.Repair(*dryRun)
+		if err != nil {
+			errs = errors.Join(errs, err)
+		}
+
+		if report != nil {
+			reports = append(reports, report)
+			failed = failed || len(report.Failed) > 0
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(reports); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	} else {
+		for _, report := range reports {
+			writeRepairReport(c.stdout, report, *dryRun)
+		}
+	}
+
+	if errs != nil {
+		return c.fail(errs)
+	}
+
+	if failed {
+		return 1
+	}
+
+	return 0
+}
+
+// writeRepairReport writes the repair report in a human readable form
+func writeRepairReport(w io.Writer, report *RepairReport, dryRun bool) {
+	if report.Account != "" {
+		fmt.Fprintf(w, "account %s:\n", report.Account)
+	}
+
+	verb := "copied"
+	if dryRun {
+		verb = "would be copied"
+	}
+
+	fmt.Fprintf(w, "%d files %s\n", len(report.Repaired), verb)
+	for _, file := range report.Repaired {
+		fmt.Fprintf(w, "  %s from %s to %s\n", file.Path, file.From, file.To)
+	}
+
+	if len(report.Failed) > 0 {
+		fmt.Fprintf(w, "%d files can't be repaired\n", len(report.Failed))
+		for _, file := range report.Failed {
+			fmt.Fprintf(w, "  %s in %s: %s\n", file.Path, file.Destination, file.Reason)
+		}
+	}
+
+	fmt.Fprintln(w)
+}
+
+func (c *command) config(args []string) int {
+	fset := c.flags("validate")
+	if len(args) == 0 || args[0] != "validate" {
+		fset.Usage()
+		return 2
+	}
+
+	if err := fset.Parse(args[1:]); err != nil {
+		return exitCode(err)
+	}
+
+	if _, err := LoadConfig(c.configPath); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		return 1
+	}
+
+	fmt.Fprintln(c.stdout, "configuration is valid")
+	return 0
+}
+
+func (c *command) filters(args []string) int {
+	fset := c.flags("test [-id id] [-host email] [-user id] <topic>")
+	if len(args) == 0 || args[0] != "test" {
+		fset.Usage()
+		return 2
+	}
+
+	meeting := Meeting{}
+	id := fset.String("id", "", "id or uuid of the meeting")
+	fset.StringVar(&meeting.HostEmail, "host", "", "email of the host")
+	fset.StringVar(&meeting.HostID, "user", "", "user id of the host")
+	if err := fset.Parse(args[1:]); err != nil {
+		return exitCode(err)
+	}
+
+	if fset.NArg() != 1 {
+		fset.Usage()
+		return 2
+	}
+
+	meeting.Topic = fset.Arg(0)
+	if n, err := strconv.Atoi(*id); err == nil {
+		meeting.ID = n
+	} else {
+		meeting.UUID = *id
+	}
+
+	configs, err := c.configs()
+	if err != nil {
+		return c.fail(err)
+	}
+
+	for _, cfg := range configs {
+		filters, err := cfg.Filters()
+		if err != nil {
+			return c.fail(err)
+		}
+
+		verdict := "skipped"
+		included, reason := filters.IncludedMeeting(meeting)
+		if included {
+			verdict = "swept"
+		}
+
+		if cfg.Name != "" {
+			fmt.Fprintf(c.stdout, "account %s: ", cfg.Name)
+		}
+
+		fmt.Fprintf(c.stdout, "%s, %s\n", verdict, reason)
+	}
+
+	return 0
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCommands(t *testing.T) {
+	mock := SetupMockAPI(t)
+	dir := t.TempDir()
+
+	t.Setenv("ZOOMDL_API_ENDPOINT", mock.baseURL.String())
+	t.Setenv("ZOOMDL_AUTH_ENDPOINT", mock.baseURL.String())
+	t.Setenv("ZOOMDL_USER_ID", "account")
+	t.Setenv("ZOOMDL_CLIENT_ID", "client")
+	t.Setenv("ZOOMDL_CLIENT_SECRET", "secret")
+	t.Setenv("ZOOMDL_DESTINATIONS", "file://"+dir)
+	t.Setenv("ZOOMDL_RECORDING_TYPES", "gallery_view")
+	t.Setenv("ZOOMDL_START_YEAR", "2022")
+	t.Setenv("ZOOMDL_RATE_LIMIT_MEDIUM", "0") // don't pace the calls to the mock
+
+	out := &bytes.Buffer{}
+	if code := runCommand([]string{"sweep", "-dry-run", "-json"}, out); code != 0 {
+		t.Fatalf("expected dry run exit code 0 but got %d", code)
+	}
+
+	plans := []SweepPlan{}
+	if err := json.Unmarshal(out.Bytes(), &plans); err != nil {
+		t.Fatalf("unable to decode the dry run plan: %v", err)
+	}
+	assert(t, len(plans) == 1 && len(plans[0].Downloads) > 0, "dry run must plan the downloads")
+	assertFileNotExists(t, path.Join(dir, "static"))
+
+	out.Reset()
+	if code := runCommand([]string{"sweep", "-once"}, out); code != 0 {
+		t.Fatalf("expected sweep exit code 0 but got %d", code)
+	}
+
+	assertFileExists(t, path.Join(dir, "static/2022-10-01_00-00-00_gallery_view.mp4"))
+
+	out.Reset()
+	if code := runCommand([]string{"status"}, out); code != 0 {
+		t.Fatalf("expected status exit code 0 but got %d", code)
+	}
+	assert(t, strings.Contains(out.String(), "recording files:"), "status must summarise the records", out.String())
+
+	out.Reset()
+	if code := runCommand([]string{"list", "-from", "2022-10-01"}, out); code != 0 {
+		t.Fatalf("expected list exit code 0 but got %d", code)
+	}
+	archived := false
+	for line := range strings.Lines(out.String()) {
+		fields := strings.Fields(line)
+		archived = archived || slices.Equal(fields[len(fields)-2:], []string{"gallery_view", "yes"})
+	}
+	assert(t, archived, "list must show the archived recordings", out.String())
+
+	out.Reset()
+	if code := runCommand([]string{"verify"}, out); code != 0 {
+		t.Fatalf("expected verify exit code 0 but got %d: %s", code, out.String())
+	}
+
+	_ = os.WriteFile(path.Join(dir, "static/2022-10-01_00-00-00_gallery_view.mp4"), []byte("some other file!"), 0o644) //nolint: errcheck
+	_ = os.WriteFile(path.Join(dir, "static/unknown.mp4"), []byte("unknown"), 0o644)                                   //nolint: errcheck
+	_ = os.Remove(path.Join(dir, "static/2022-11-01_00-00-00_gallery_view.mp4"))                                       //nolint: errcheck
+
+	out.Reset()
+	if code := runCommand([]string{"verify", "-json"}, out); code != 1 {
+		t.Fatalf("expected verify exit code 1 but got %d", code)
+	}
+
+	reports := []VerifyReport{}
+	if err := json.Unmarshal(out.Bytes(), &reports); err != nil {
+		t.Fatalf("unable to decode the verify report: %v", err)
+	}
+
+	dst := reports[0].Destinations[0]
+	assert(t, slices.Equal(dst.Missing, []string{"static/2022-11-01_00-00-00_gallery_view.mp4"}), "verify must report the missing file", out.String())
+	assert(t, len(dst.Corrupted) == 1 && dst.Corrupted[0].Path == "static/2022-10-01_00-00-00_gallery_view.mp4", "verify must report the corrupted file", out.String())
+	assert(t, slices.Equal(dst.Orphaned, []string{"static/unknown.mp4"}), "verify must report the orphaned file", out.String())
+
+	out.Reset()
+	_ = runCommand([]string{"verify", "-quick", "-json"}, out)
+	reports = []VerifyReport{}
+	_ = json.Unmarshal(out.Bytes(), &reports) //nolint: errcheck
+	assert(t, len(reports[0].Destinations[0].Corrupted) == 0, "quick verify must not compare the hashes")
+
+	t.Setenv("ZOOMDL_ALL_USERS", "true")
+	if code := runCommand([]string{"download", "/a//b=="}, out); code != 0 {
+		t.Fatalf("expected download exit code 0 but got %d", code)
+	}
+
+	assertFileExists(t, path.Join(dir, "colleague@example.com/slashed/2021-03-03_00-00-00_gallery_view.mp4"))
+
+	if code := runCommand([]string{"unknown"}, out); code != 2 {
+		t.Errorf("expected exit code 2 for an unknown command but got %d", code)
+	}
+
+	t.Setenv("ZOOMDL_EXCLUDE_RULES", "topic=re:^Board && host=glob:*@legal.example.com")
+	out.Reset()
+	if code := runCommand([]string{"filters", "test", "-host", "Counsel@legal.example.com", "Board meeting"}, out); code != 0 {
+		t.Fatalf("expected filters test exit code 0 but got %d", code)
+	}
+	assert(t, strings.Contains(out.String(), "skipped, exclude rule 1"), "filters test must show the matching rule", out.String())
+
+	out.Reset()
+	_ = runCommand([]string{"filters", "test", "Board meeting"}, out)
+	assert(t, strings.HasPrefix(out.String(), "swept"), "filters test must show the meeting is swept", out.String())
+
+	t.Setenv("ZOOMDL_DURATION", "never")
+	if code := runCommand([]string{"config", "validate"}, out); code != 1 {
+		t.Errorf("expected exit code 1 for an invalid config but got %d", code)
+	}
+}
+
+func TestReadOnlyCommands(t *testing.T) {
+	mock := SetupMockAPI(t)
+	dir := t.TempDir()
+	db := path.Join(t.TempDir(), "state.db")
+
+	t.Setenv("ZOOMDL_API_ENDPOINT", mock.baseURL.String())
+	t.Setenv("ZOOMDL_AUTH_ENDPOINT", mock.baseURL.String())
+	t.Setenv("ZOOMDL_USER_ID", "account")
+	t.Setenv("ZOOMDL_CLIENT_ID", "client")
+	t.Setenv("ZOOMDL_CLIENT_SECRET", "secret")
+	t.Setenv("ZOOMDL_DESTINATIONS", "file://"+dir)
+	t.Setenv("ZOOMDL_RECORDING_TYPES", "gallery_view")
+	t.Setenv("ZOOMDL_START_YEAR", "2022")
+	t.Setenv("ZOOMDL_RATE_LIMIT_MEDIUM", "0")
+	t.Setenv("ZOOMDL_STATE_DB", db)
+
+	readOnly := func() {
+		t.Helper()
+
+		out := &bytes.Buffer{}
+		if code := runCommand([]string{"status"}, out); code != 0 {
+			t.Fatalf("expected status exit code 0 but got %d: %s", code, out.String())
+		}
+
+		if code := runCommand([]string{"list", "-from", "2022-10-01"}, out); code != 0 {
+			t.Fatalf("expected list exit code 0 but got %d: %s", code, out.String())
+		}
+	}
+
+	readOnly()
+	assertFileNotExists(t, db)
+
+	if code := runCommand([]string{"sweep", "-once"}, &bytes.Buffer{}); code != 0 {
+		t.Fatalf("expected sweep exit code 0 but got %d", code)
+	}
+
+	listState := func() map[string]int64 {
+		entries, _ := os.ReadDir(path.Dir(db)) //nolint: errcheck
+		files := map[string]int64{}
+		for _, entry := range entries {
+			info, _ := entry.Info() //nolint: errcheck
+			files[entry.Name()] = info.ModTime().UnixNano()
+		}
+
+		return files
+	}
+
+	before := listState()
+	readOnly()
+	assert(t, maps.Equal(before, listState()), "status and list must not change the state database")
+}
+
+func TestVerifySharedDestination(t *testing.T) {
+	mock := SetupMockAPI(t)
+	dir := t.TempDir()
+
+	t.Setenv("ZOOMDL_API_ENDPOINT", mock.baseURL.String())
+	t.Setenv("ZOOMDL_AUTH_ENDPOINT", mock.baseURL.String())
+	t.Setenv("ZOOMDL_ACCOUNTS", "hr;sales")
+	t.Setenv("ZOOMDL_DESTINATIONS", "file://"+dir)
+	t.Setenv("ZOOMDL_RECORDING_TYPES", "gallery_view")
+	t.Setenv("ZOOMDL_START_YEAR", "2022")
+	t.Setenv("ZOOMDL_RATE_LIMIT_MEDIUM", "0")
+	t.Setenv("ZOOMDL_SALES_PATH_TEMPLATE", "sales/"+DefaultPathTemplate)
+
+	for _, acc := range []string{"HR", "SALES"} {
+		t.Setenv("ZOOMDL_"+acc+"_USER_ID", "account")
+		t.Setenv("ZOOMDL_"+acc+"_CLIENT_ID", "client")
+		t.Setenv("ZOOMDL_"+acc+"_CLIENT_SECRET", "secret")
+	}
+
+	out := &bytes.Buffer{}
+	if code := runCommand([]string{"sweep", "-once"}, out); code != 0 {
+		t.Fatalf("expected sweep exit code 0 but got %d", code)
+	}
+
+	assertFileExists(t, path.Join(dir, ".zoomdl_saved_records_sales.json"))
+	assertFileExists(t, path.Join(dir, "sales/static/2022-10-01_00-00-00_gallery_view.mp4"))
+
+	out.Reset()
+	if code := runCommand([]string{"verify", "-account", "hr"}, out); code != 0 {
+		t.Fatalf("files of the other account must not be orphaned, got exit code %d: %s", code, out.String())
+	}
+
+	_ = os.WriteFile(path.Join(dir, "sales/unknown.mp4"), []byte("unknown"), 0o644) //nolint: errcheck
+
+	out.Reset()
+	if code := runCommand([]string{"verify", "-json"}, out); code != 1 {
+		t.Fatalf("expected verify exit code 1 but got %d", code)
+	}
+
+	reports := []VerifyReport{}
+	if err := json.Unmarshal(out.Bytes(), &reports); err != nil {
+		t.Fatalf("unable to decode the verify report: %v", err)
+	}
+
+	assert(t, len(reports) == 2, "every account must be verified")
+	for _, report := range reports {
+		assert(t, slices.Equal(report.Destinations[0].Orphaned, []string{"sales/unknown.mp4"}), "only the unknown file must be orphaned", out.String())
+	}
+}
+
+func TestDaemonAccount(t *testing.T) {
+	mock := SetupMockAPI(t)
+
+	t.Setenv("ZOOMDL_API_ENDPOINT", mock.baseURL.String())
+	t.Setenv("ZOOMDL_AUTH_ENDPOINT", mock.baseURL.String())
+	t.Setenv("ZOOMDL_ACCOUNTS", "hr;sales")
+	t.Setenv("ZOOMDL_DESTINATIONS", "file://"+t.TempDir())
+
+	for _, acc := range []string{"HR", "SALES"} {
+		t.Setenv("ZOOMDL_"+acc+"_USER_ID", "account")
+		t.Setenv("ZOOMDL_"+acc+"_CLIENT_ID", "client")
+		t.Setenv("ZOOMDL_"+acc+"_CLIENT_SECRET", "secret")
+	}
+
+	for _, name := range []string{"run", "sweep"} {
+		var (
+			mu     sync.Mutex
+			served []string
+		)
+
+		cmd := &command{name: name, stdout: &bytes.Buffer{}, serve: func(zc *ZoomClient) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			served = append(served, zc.config.Name)
+		}}
+
+		run := cmd.run
+		if name == "sweep" {
+			run = cmd.sweep
+		}
+
+		code := run([]string{"-account", "hr"})
+
+		assert(t, code == 0, name, "must exit with code 0")
+		assert(t, slices.Equal(served, []string{"hr"}), name, "must only serve the selected account:", strings.Join(served, ", "))
+	}
+}
@@ -0,0 +1,583 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config defines the application configuration
+type Config struct {
+	Name             string
+	Accounts         []*Config
+	SavedRecordsFile string
+	RecordingTypes   []string
+	IgnoreTitles     []string
+	IncludeRules     []string
+	ExcludeRules     []string
+	AllUsers         bool
+	IncludeUsers     []string
+	ExcludeUsers     []string
+	Destinations     []string
+	Routes           []string
+	PathTemplate     string
+	TimeLayout       string
+	Timezone         string
+	WritePolicy      string
+	StateDB          string
+	DeleteAfter      bool
+	DeleteAction     string
+	DeleteMode       string
+	DeleteAfterDays  int
+	Retry            RetryPolicy
+	Duration         time.Duration
+	Token            string
+	APIEndpoint      *url.URL
+	AuthEndpoint     *url.URL
+	UserID           string
+	ClientID         string
+	ClientSecret     Secret
+	Concurrency      int
+	ChunckSizeMB     int
+	StartingFromYear int
+}
+
+// Secret is a sensitive setting that is redacted when printed
+type Secret string
+
+// String is an implementation of fmt.Stringer
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+
+	return "[redacted]"
+}
+
+// GoString is an implementation of fmt.GoStringer
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+// MarshalJSON is an implementation of json.Marshaler
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// fileConfig is the layout of the yaml config file, the settings use the
+// snake case name of the environment variable without the ZOOMDL_ prefix
+type fileConfig struct {
+	Values   map[string]any   `yaml:",inline"`
+	Accounts []map[string]any `yaml:"accounts"`
+}
+
+// LoadConfig returns a new initialized config from the given yaml config
+// file (optional) where the environment variables override the file values,
+// when accounts are defined the config contains a config for every account
+func LoadConfig(configPath string) (*Config, error) {
+	file := fileConfig{}
+	if configPath != "" {
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read config file: %w", err)
+		}
+
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("unable to parse config file '%s': %w", configPath, err)
+		}
+	}
+
+	global, err := flattenValues(file.Values)
+	if err != nil {
+		return nil, fmt.Errorf("config file '%s': %w", configPath, err)
+	}
+
+	accounts := map[string]map[string]string{}
+	fileAccountNames := []string{}
+	for i, values := range file.Accounts {
+		acc, err := flattenValues(values)
+		if err != nil {
+			return nil, fmt.Errorf("config file '%s': accounts[%d]: %w", configPath, i, err)
+		}
+
+		name := acc["NAME"]
+		if name == "" {
+			return nil, fmt.Errorf("config file '%s': accounts[%d]: missing name", configPath, i)
+		}
+
+		if _, exists := accounts[name]; exists {
+			return nil, fmt.Errorf("config file '%s': duplicate account '%s'", configPath, name)
+		}
+
+		delete(acc, "NAME")
+		accounts[name] = acc
+		fileAccountNames = append(fileAccountNames, name)
+	}
+
+	e := &environment{global: global, used: map[string]bool{}}
+	c := loadConfig(e)
+
+	names := e.list("ACCOUNTS")
+	if len(names) == 0 {
+		names = fileAccountNames
+	}
+
+	if len(names) == 0 {
+		c.UserID = e.required("USER_ID")
+		c.ClientID = e.required("CLIENT_ID")
+		c.ClientSecret = Secret(e.required("CLIENT_SECRET"))
+	}
+
+	// the names are compared as they're used in the env keys and the saved
+	// records file, e.g. sales-eu and sales_eu are the same account
+	keys := map[string]string{}
+	for _, name := range names {
+		if other, exists := keys[envKey(name)]; exists {
+			e.err = errors.Join(e.err, fmt.Errorf("accounts '%s' and '%s' both use ZOOMDL_%s_*, rename one of them", other, name, envKey(name)))
+			delete(accounts, name)
+			continue
+		}
+
+		keys[envKey(name)] = name
+
+		ae := &environment{account: name, file: accounts[name], global: global, used: map[string]bool{}}
+
+		acc := loadConfig(ae)
+		acc.Name = name
+		acc.SavedRecordsFile = fmt.Sprintf(".zoomdl_saved_records_%s.json", strings.ToLower(envKey(name)))
+		acc.UserID = ae.required("USER_ID")
+		acc.ClientID = ae.required("CLIENT_ID")
+		acc.ClientSecret = Secret(ae.required("CLIENT_SECRET"))
+
+		c.Accounts = append(c.Accounts, acc)
+		e.err = errors.Join(e.err, ae.err, ae.unknownKeys(configPath))
+		delete(accounts, name)
+	}
+
+	for name := range accounts {
+		e.err = errors.Join(e.err, fmt.Errorf("config file '%s': account '%s' is not in ZOOMDL_ACCOUNTS", configPath, name))
+	}
+
+	e.err = errors.Join(e.err, e.unknownKeys(configPath))
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func loadConfig(e *environment) *Config {
+	c := &Config{}
+
+	c.RecordingTypes = strings.Split(e.get("RECORDING_TYPES"), ";")
+	c.IgnoreTitles = strings.Split(e.get("IGNORE_TITLES"), ";")
+	c.IncludeRules = e.list("INCLUDE_RULES")
+	c.ExcludeRules = e.list("EXCLUDE_RULES")
+
+	c.AllUsers = e.bool("ALL_USERS")
+	c.IncludeUsers = strings.Split(e.get("INCLUDE_USERS"), ";")
+	c.ExcludeUsers = strings.Split(e.get("EXCLUDE_USERS"), ";")
+
+	c.Destinations = strings.Split(e.get("DESTINATIONS"), ";")
+	if dir := e.get("DIR"); dir != "" { // backwards compatibility
+		c.Destinations = append(c.Destinations, fmt.Sprintf("file://%s", dir))
+	}
+
+	c.Routes = e.list("ROUTES")
+	c.PathTemplate = e.string("PATH_TEMPLATE", DefaultPathTemplate)
+	c.TimeLayout = e.string("TIME_LAYOUT", DefaultTimeLayout)
+	c.Timezone = e.string("TIMEZONE", "UTC")
+	c.WritePolicy = e.string("WRITE_POLICY", "all")
+	c.StateDB = e.get("STATE_DB")
+
+	c.APIEndpoint = e.url("API_ENDPOINT", "https://api.zoom.us/v2")
+	c.AuthEndpoint = e.url("AUTH_ENDPOINT", "https://zoom.us")
+	c.StartingFromYear = e.int("START_YEAR", 2018)
+	c.Concurrency = e.int("CONCURRENCY", 4)
+	c.ChunckSizeMB = e.int("CHUNKSIZE_MB", 256)
+
+	c.Duration = e.duration("DURATION", "30m")
+	c.DeleteAfter = e.bool("DELETE_AFTER")
+	c.DeleteAction = e.string("DELETE_ACTION", "trash")
+	c.DeleteMode = e.string("DELETE_MODE", "meeting")
+	c.DeleteAfterDays = e.int("DELETE_AFTER_DAYS", 0)
+
+	c.Retry = RetryPolicy{
+		MaxRetries: e.int("RETRY_MAX", 5),
+		MinBackoff: e.duration("RETRY_MIN_BACKOFF", "1s"),
+		MaxBackoff: e.duration("RETRY_MAX_BACKOFF", "1m"),
+		RateLimits: map[RateCategory]int{ // defaults of the pro plan
+			RateCategoryLight:  e.int("RATE_LIMIT_LIGHT", 30),
+			RateCategoryMedium: e.int("RATE_LIMIT_MEDIUM", 20),
+			RateCategoryHeavy:  e.int("RATE_LIMIT_HEAVY", 10),
+		},
+	}
+
+	c.SavedRecordsFile = SavedRecordFileName
+
+	return c
+}
+
+// validateDestination checks if the destination url is supported
+func validateDestination(dst string) error {
+	u, err := url.Parse(dst)
+	if err != nil { // the error is left out since it contains the credentials
+		return errors.New("invalid destination url")
+	}
+
+	if u.Scheme != "file" && u.Scheme != "s3" {
+		return fmt.Errorf("unsupported destination scheme '%s', use file:// or s3://", u.Scheme)
+	}
+
+	return nil
+}
+
+// Validate checks if the config is usable
+func (c *Config) Validate() error {
+	if len(c.Accounts) > 0 {
+		var errs error
+		for _, acc := range c.Accounts {
+			if err := acc.Validate(); err != nil {
+				errs = errors.Join(errs, fmt.Errorf("account '%s': %w", acc.Name, err))
+			}
+		}
+
+		return errs
+	}
+
+	var errs error
+	destinations := 0
+	for _, dst := range c.Destinations {
+		if dst == "" {
+			continue
+		}
+		destinations++
+
+		if err := validateDestination(dst); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("destination #%d: %w", destinations, err))
+		}
+	}
+
+	if destinations == 0 {
+		errs = errors.Join(errs, errors.New("no destinations configured"))
+	}
+
+	routes, err := c.ParseRoutes()
+	errs = errors.Join(errs, err)
+
+	for _, route := range routes {
+		for _, dst := range route.Destinations {
+			if err := validateDestination(dst); err != nil {
+				errs = errors.Join(errs, fmt.Errorf("route '%s': %w", route.Source, err))
+			}
+		}
+	}
+
+	if c.Concurrency < 1 {
+		errs = errors.Join(errs, fmt.Errorf("concurrency must be at least 1, got %d", c.Concurrency))
+	}
+
+	if c.ChunckSizeMB < 1 {
+		errs = errors.Join(errs, fmt.Errorf("chunksize_mb must be at least 1, got %d", c.ChunckSizeMB))
+	}
+
+	if !slices.Contains([]string{"all", "quorum", "any"}, c.WritePolicy) {
+		errs = errors.Join(errs, fmt.Errorf("write_policy must be all, quorum or any, got '%s'", c.WritePolicy))
+	}
+
+	if c.DeleteAction != "trash" && c.DeleteAction != "delete" {
+		errs = errors.Join(errs, fmt.Errorf("delete_action must be trash or delete, got '%s'", c.DeleteAction))
+	}
+
+	if c.DeleteMode != "meeting" && c.DeleteMode != "file" {
+		errs = errors.Join(errs, fmt.Errorf("delete_mode must be meeting or file, got '%s'", c.DeleteMode))
+	}
+
+	if c.DeleteAfterDays < 0 {
+		errs = errors.Join(errs, fmt.Errorf("delete_after_days can't be negative, got %d", c.DeleteAfterDays))
+	}
+
+	if c.Retry.MaxRetries < 0 {
+		errs = errors.Join(errs, fmt.Errorf("retry_max can't be negative, got %d", c.Retry.MaxRetries))
+	}
+
+	if c.Retry.MinBackoff <= 0 || c.Retry.MaxBackoff < c.Retry.MinBackoff {
+		errs = errors.Join(errs, fmt.Errorf("retry_min_backoff must be positive and at most retry_max_backoff, got %s and %s", c.Retry.MinBackoff, c.Retry.MaxBackoff))
+	}
+
+	for category, limit := range c.Retry.RateLimits {
+		if limit < 0 {
+			errs = errors.Join(errs, fmt.Errorf("rate_limit_%s can't be negative, got %d", strings.ToLower(string(category)), limit))
+		}
+	}
+
+	if c.Duration <= 0 {
+		errs = errors.Join(errs, fmt.Errorf("duration must be positive, got %s", c.Duration))
+	}
+
+	if _, err := c.Filters(); err != nil {
+		errs = errors.Join(errs, err)
+	}
+
+	if _, err := c.Paths(); err != nil {
+		errs = errors.Join(errs, err)
+	}
+
+	return errs
+}
+
+// flattenValues converts the values of the config file to the form of the
+// environment variables, lists are joined with ';'
+func flattenValues(values map[string]any) (map[string]string, error) {
+	flat := make(map[string]string, len(values))
+
+	for key, val := range values {
+		switch v := val.(type) {
+		case nil:
+			continue
+		case []any:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				if _, isMap := item.(map[string]any); isMap {
+					return nil, fmt.Errorf("'%s' must be a list of values", key)
+				}
+
+				items = append(items, fmt.Sprint(item))
+			}
+
+			flat[strings.ToUpper(key)] = strings.Join(items, ";")
+		case map[string]any:
+			return nil, fmt.Errorf("unexpected section '%s'", key)
+		default:
+			flat[strings.ToUpper(key)] = fmt.Sprint(v)
+		}
+	}
+
+	return flat, nil
+}
+
+// environment reads the settings from the ZOOMDL_ prefixed environment
+// variables and the config file values, when an account is set the account
+// specific settings (e.g. ZOOMDL_<ACCOUNT>_DURATION) take precedence over
+// the global ones. Errors are collected so they can all be reported at once.
+type environment struct {
+	account string
+	file    map[string]string
+	global  map[string]string
+	used    map[string]bool
+	err     error
+}
+
+func (e *environment) name(key string) string {
+	if e.account == "" {
+		return "ZOOMDL_" + key
+	}
+
+	return fmt.Sprintf("ZOOMDL_%s_%s", envKey(e.account), key)
+}
+
+func (e *environment) get(key string) string {
+	if e.account != "" {
+		if val := e.lookup(e.name(key), e.file, key); val != "" {
+			return val
+		}
+	}
+
+	return e.lookup("ZOOMDL_"+key, e.global, key)
+}
+
+// required returns the value of the setting, it never falls back
+// to the global setting so credentials are never shared by accident
+func (e *environment) required(key string) string {
+	values := e.global
+	if e.account != "" {
+		values = e.file
+	}
+
+	val := e.lookup(e.name(key), values, key)
+	if val == "" {
+		e.fail(key, "missing required setting")
+	}
+
+	return val
+}
+
+// lookup returns the setting from the environment variable or else from the
+// config file values. Every setting can also be read from a file (<KEY>_FILE)
+// or from the output of a command (<KEY>_COMMAND) so secrets never have to be
+// in the environment or the config file itself.
+func (e *environment) lookup(envName string, values map[string]string, key string) string {
+	e.used[key] = true
+	e.used[key+"_FILE"] = true
+	e.used[key+"_COMMAND"] = true
+
+	if val := os.Getenv(envName); val != "" {
+		return val
+	}
+
+	if p := os.Getenv(envName + "_FILE"); p != "" {
+		return e.secretFile(key, p)
+	}
+
+	if command := os.Getenv(envName + "_COMMAND"); command != "" {
+		return e.secretCommand(key, command)
+	}
+
+	if val := values[key]; val != "" {
+		return val
+	}
+
+	if p := values[key+"_FILE"]; p != "" {
+		return e.secretFile(key, p)
+	}
+
+	if command := values[key+"_COMMAND"]; command != "" {
+		return e.secretCommand(key, command)
+	}
+
+	return ""
+}
+
+func (e *environment) secretFile(key, p string) string {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		e.fail(key, "unable to read secret file: %v", err)
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+func (e *environment) secretCommand(key, command string) string {
+	out, err := exec.Command("sh", "-c", command).Output()
+	if err != nil {
+		e.fail(key, "secret command failed: %v", err)
+		return ""
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+func (e *environment) fail(key, format string, args ...any) {
+	e.err = errors.Join(e.err, fmt.Errorf("%s (%s): %s", e.name(key), strings.ToLower(key), fmt.Sprintf(format, args...)))
+}
+
+func (e *environment) list(key string) []string {
+	list := []string{}
+	for s := range strings.SplitSeq(e.get(key), ";") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+
+	return list
+}
+
+func (e *environment) string(key, defaultStr string) string {
+	return cmp.Or(e.get(key), defaultStr)
+}
+
+func (e *environment) bool(key string) bool {
+	val := e.get(key)
+	if val == "" {
+		return false
+	}
+
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		e.fail(key, "invalid boolean '%s'", val)
+	}
+
+	return b
+}
+
+func (e *environment) duration(key, defaultDuration string) time.Duration {
+	val := e.string(key, defaultDuration)
+
+	dur, err := time.ParseDuration(val)
+	if err != nil {
+		e.fail(key, "invalid duration '%s'", val)
+	}
+
+	return dur
+}
+
+func (e *environment) url(key, defaultURL string) *url.URL {
+	val := e.string(key, defaultURL)
+
+	u, err := url.Parse(val)
+	if err != nil {
+		e.fail(key, "invalid url '%s'", val)
+	}
+
+	return u
+}
+
+func (e *environment) int(key string, defaultInt int) int {
+	val := e.get(key)
+	if val == "" {
+		return defaultInt
+	}
+
+	i, err := strconv.Atoi(val)
+	if err != nil {
+		e.fail(key, "invalid number '%s'", val)
+		return defaultInt
+	}
+
+	return i
+}
+
+// unknownKeys reports the config file settings that are never read
+func (e *environment) unknownKeys(configPath string) error {
+	values := e.global
+	if e.account != "" {
+		values = e.file
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		if !e.used[key] {
+			keys = append(keys, strings.ToLower(key))
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	slices.Sort(keys)
+	if e.account != "" {
+		return fmt.Errorf("config file '%s': account '%s': unknown settings %s", configPath, e.account, strings.Join(keys, ", "))
+	}
+
+	return fmt.Errorf("config file '%s': unknown settings %s", configPath, strings.Join(keys, ", "))
+}
+
+// envKey converts an account name to its environment variable form
+func envKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
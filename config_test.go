@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigAccounts(t *testing.T) {
+	t.Setenv("ZOOMDL_ACCOUNTS", "hr;sales-eu")
+	t.Setenv("ZOOMDL_DESTINATIONS", "file:///archive")
+	t.Setenv("ZOOMDL_RECORDING_TYPES", "gallery_view")
+	t.Setenv("ZOOMDL_HR_USER_ID", "hr-account")
+	t.Setenv("ZOOMDL_HR_CLIENT_ID", "hr-client")
+	t.Setenv("ZOOMDL_HR_CLIENT_SECRET", "hr-secret")
+	t.Setenv("ZOOMDL_HR_DESTINATIONS", "file:///hr")
+	t.Setenv("ZOOMDL_SALES_EU_USER_ID", "sales-account")
+	t.Setenv("ZOOMDL_SALES_EU_CLIENT_ID", "sales-client")
+	t.Setenv("ZOOMDL_SALES_EU_CLIENT_SECRET", "sales-secret")
+
+	c, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("unexpected error loading config: %v", err)
+	}
+
+	if e, a := 2, len(c.Accounts); e != a {
+		t.Fatalf("expected %d accounts but got %d", e, a)
+	}
+
+	hr, sales := c.Accounts[0], c.Accounts[1]
+	assert(t, hr.ClientID == "hr-client", "hr must have its own credentials")
+	assert(t, sales.ClientID == "sales-client", "sales must have its own credentials")
+	assert(t, hr.Destinations[0] == "file:///hr", "hr must use its own destination")
+	assert(t, sales.Destinations[0] == "file:///archive", "sales must fall back to the global destination")
+	assert(t, sales.RecordingTypes[0] == "gallery_view", "sales must fall back to the global recording types")
+	assert(t, hr.SavedRecordsFile != sales.SavedRecordsFile, "accounts must not share a saved records file")
+	assert(t, sales.SavedRecordsFile == ".zoomdl_saved_records_sales_eu.json", "unexpected saved records file", sales.SavedRecordsFile)
+}
+
+func TestLoadConfigAccountNames(t *testing.T) {
+	t.Setenv("ZOOMDL_ACCOUNTS", "sales-eu;sales_eu")
+	t.Setenv("ZOOMDL_DESTINATIONS", "file:///archive")
+	t.Setenv("ZOOMDL_SALES_EU_USER_ID", "sales-account")
+	t.Setenv("ZOOMDL_SALES_EU_CLIENT_ID", "sales-client")
+	t.Setenv("ZOOMDL_SALES_EU_CLIENT_SECRET", "sales-secret")
+
+	_, err := LoadConfig("")
+	assert(t, err != nil && strings.Contains(err.Error(), "accounts 'sales-eu' and 'sales_eu' both use ZOOMDL_SALES_EU_*"), "accounts with the same env key must return an error")
+
+	t.Setenv("ZOOMDL_ACCOUNTS", "")
+	configPath := writeConfigFile(t, `
+destinations: [file:///archive]
+accounts:
+  - name: Sales
+    user_id: sales-account
+    client_id: sales-client
+    client_secret: sales-secret
+  - name: sales
+    user_id: other-account
+    client_id: other-client
+    client_secret: other-secret
+`)
+
+	_, err = LoadConfig(configPath)
+	assert(t, err != nil && strings.Contains(err.Error(), "accounts 'Sales' and 'sales' both use ZOOMDL_SALES_*"), "accounts in the config file with the same env key must return an error")
+	assert(t, err != nil && !strings.Contains(err.Error(), "is not in ZOOMDL_ACCOUNTS"), "account with the same env key must only be reported once")
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	configPath := writeConfigFile(t, `
+destinations:
+  - file:///archive
+recording_types: [gallery_view, speaker_view]
+duration: 1h
+concurrency: 8
+delete_after: true
+accounts:
+  - name: hr
+    user_id: hr-account
+    client_id: hr-client
+    client_secret: hr-secret
+    destinations: [file:///hr]
+  - name: sales
+    user_id: sales-account
+    client_id: sales-client
+    client_secret: sales-secret
+`)
+
+	t.Setenv("ZOOMDL_CONCURRENCY", "2")
+	t.Setenv("ZOOMDL_SALES_DURATION", "5m")
+
+	c, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("unexpected error loading config: %v", err)
+	}
+
+	if e, a := 2, len(c.Accounts); e != a {
+		t.Fatalf("expected %d accounts but got %d", e, a)
+	}
+
+	hr, sales := c.Accounts[0], c.Accounts[1]
+	assert(t, hr.Destinations[0] == "file:///hr", "hr must use its own destination")
+	assert(t, sales.Destinations[0] == "file:///archive", "sales must fall back to the global destination")
+	assert(t, len(hr.RecordingTypes) == 2 && hr.RecordingTypes[1] == "speaker_view", "recording types must be read from the list")
+	assert(t, hr.Duration == time.Hour, "hr must use the file duration")
+	assert(t, sales.Duration == 5*time.Minute, "environment must override the file duration")
+	assert(t, hr.Concurrency == 2, "environment must override the file concurrency")
+	assert(t, hr.DeleteAfter, "delete after must be read from the file")
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	configPath := writeConfigFile(t, `
+user_id: account
+client_secret: secret
+destinations: [ftp://archive]
+duration: forever
+concurency: 8
+`)
+
+	_, err := LoadConfig(configPath)
+	if err == nil {
+		t.Fatal("expected an error loading an invalid config")
+	}
+
+	for _, expected := range []string{
+		"ZOOMDL_CLIENT_ID (client_id): missing required setting",
+		"ZOOMDL_DURATION (duration): invalid duration 'forever'",
+		"unknown settings concurency",
+	} {
+		assert(t, strings.Contains(err.Error(), expected), "expected error", expected, "in", err.Error())
+	}
+
+	configPath = writeConfigFile(t, `
+user_id: account
+client_id: client
+client_secret: secret
+destinations: [ftp://archive]
+`)
+
+	_, err = LoadConfig(configPath)
+	assert(t, err != nil && strings.Contains(err.Error(), "unsupported destination scheme 'ftp'"), "expected a destination error")
+
+	configPath = writeConfigFile(t, `
+user_id: account
+client_id: client
+client_secret: secret
+destinations: [file:///archive]
+ignore_titles: ["re:(unclosed"]
+`)
+
+	_, err = LoadConfig(configPath)
+	assert(t, err != nil && strings.Contains(err.Error(), "ignore_titles: invalid pattern 're:(unclosed'"), "expected a pattern error")
+}
+
+func TestLoadConfigSecrets(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := path.Join(dir, "client_secret")
+	if err := os.WriteFile(secretFile, []byte("file-secret\n"), 0o600); err != nil {
+		t.Fatalf("unable to write secret file: %v", err)
+	}
+
+	configPath := writeConfigFile(t, `
+user_id: account
+client_id_command: echo command-client
+destinations: [file:///archive]
+`)
+
+	t.Setenv("ZOOMDL_CLIENT_SECRET_FILE", secretFile)
+
+	c, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("unexpected error loading config: %v", err)
+	}
+
+	assert(t, c.ClientID == "command-client", "client id must be read from the command output")
+	assert(t, string(c.ClientSecret) == "file-secret", "client secret must be read from the file")
+	assert(t, !strings.Contains(fmt.Sprintf("%v %+v %#v", c, c, c), "file-secret"), "client secret must be redacted")
+
+	t.Setenv("ZOOMDL_CLIENT_SECRET_FILE", path.Join(dir, "missing"))
+
+	_, err = LoadConfig(configPath)
+	assert(t, err != nil && strings.Contains(err.Error(), "ZOOMDL_CLIENT_SECRET (client_secret): unable to read secret file"), "expected a secret file error")
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+
+	configPath := path.Join(t.TempDir(), "zoomdl.yaml")
+	if err := os.WriteFile(configPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("unable to write config file: %v", err)
+	}
+
+	return configPath
+}
@@ -1,28 +1,112 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/jobstoit/s3io/v3"
 )
 
 type FileSystem interface {
-	Writer(ctx context.Context, target string) (io.WriteCloser, error)
+	Writer(ctx context.Context, target string) (FileWriter, error)
 	Reader(ctx context.Context, target string) (io.Reader, error)
+	Stat(ctx context.Context, target string) (FileInfo, error)
+	List(ctx context.Context, prefix string) ([]FileInfo, error)
+	// Delete removes the target, a target that doesn't exist isn't an error
+	Delete(ctx context.Context, target string) error
+	// Rename moves the target to the new name, replacing an existing file
+	Rename(ctx context.Context, target, name string) error
+	// Copy copies the target to the new name, replacing an existing file
+	Copy(ctx context.Context, target, name string) error
 }
 
-type multifs []FileSystem
+// FileWriter writes a file that only appears under its name when it's
+// committed with Close, Abort discards the written data instead
+type FileWriter interface {
+	io.WriteCloser
+	Abort() error
+}
+
+// Resumer is implemented by the file systems that can continue an
+// interrupted write
+type Resumer interface {
+	// Resume returns a writer that continues the write of the target with
+	// the state of the paused writer, a new write is started without a state
+	Resume(ctx context.Context, target, state string) (ResumableWriter, error)
+}
+
+// ResumableWriter is a writer that keeps the written data on failures so
+// the write can be resumed, the file is only completed on Close
+type ResumableWriter interface {
+	FileWriter
+	// Offset returns the amount of bytes that can be resumed from
+	Offset() int64
+	// Rewind drops the data after the offset and returns the offset the
+	// writer continues from, which can be lower than the given offset
+	Rewind(offset int64) (int64, error)
+	// State returns the state to resume the write with
+	State() string
+	// Pause closes the writer without completing the file
+	Pause() error
+}
+
+// FileInfo describes a file in a FileSystem
+type FileInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// destination is a FileSystem with the url it's opened with
+// (without the credentials) to describe it
+type destination struct {
+	FileSystem
+	name string
+}
+
+// multifs writes to every destination, the write policy decides how many
+// destinations must succeed, failing destinations are dropped from a write
+type multifs struct {
+	dsts   []destination
+	policy string
+}
+
+// required returns the amount of destinations a write must succeed in
+func (f multifs) required() int {
+	switch f.policy {
+	case "any":
+		return min(len(f.dsts), 1)
+	case "quorum":
+		return len(f.dsts)/2 + 1
+	default:
+		return len(f.dsts)
+	}
+}
 
 func newMultiFS(ctx context.Context, cfg *Config) (FileSystem, error) {
-	destinations := cfg.Destinations
+	return openDestinations(ctx, cfg, cfg.Destinations)
+}
 
-	fileSystems := make(multifs, 0, len(destinations))
+// openDestinations opens the destination urls as one file system with the
+// write policy of the config
+func openDestinations(ctx context.Context, cfg *Config, destinations []string) (FileSystem, error) {
+	fileSystems := multifs{dsts: make([]destination, 0, len(destinations)), policy: cfg.WritePolicy}
 
 	for _, dst := range destinations {
 		if dst == "" {
@@ -30,66 +114,254 @@ func newMultiFS(ctx context.Context, cfg *Config) (FileSystem, error) {
 		}
 
 		u, err := url.Parse(dst)
-		if err != nil {
-			return nil, err
+		if err != nil { // the error is left out since it contains the credentials
+			return nil, fmt.Errorf("invalid destination url")
 		}
 
 		switch u.Scheme {
 		case "file":
-			fs, err := newOsFS(u.Path)
+			local, err := newOsFS(u.Path)
 			if err != nil {
 				return nil, fmt.Errorf("unable to open '%s': %v", u.Path, err)
 			}
 
-			fileSystems = append(fileSystems, fs)
+			fileSystems.dsts = append(fileSystems.dsts, destination{FileSystem: local, name: u.Redacted()})
 		case "s3":
+			dst, err := withCredentialFiles(u)
+			if err != nil {
+				return nil, fmt.Errorf("unable to open '%s': %v", u.Redacted(), err)
+			}
+
 			bucket, err := s3io.OpenURL(ctx, dst, s3io.WithBucketConcurrency(cfg.Concurrency))
 			if err != nil {
 				return nil, err
 			}
 
-			fileSystems = append(fileSystems, &s3fs{bucket: bucket})
+			fileSystems.dsts = append(fileSystems.dsts, destination{
+				FileSystem: &s3fs{bucket: s3ioBucket{bucket}, partSize: max(int64(cfg.ChunckSizeMB), 5) * 1024 * 1024},
+				name:       u.Redacted(),
+			})
 		default:
-			return nil, fmt.Errorf("unrecognised destination '%s'", dst)
+			return nil, fmt.Errorf("unrecognised destination '%s'", u.Redacted())
 		}
 	}
 
 	return fileSystems, nil
 }
 
-func (f multifs) Writer(ctx context.Context, target string) (io.WriteCloser, error) {
-	writers := multiWriteCloser{}
+// withCredentialFiles returns the destination url with the credentials read
+// from the files in the access_key_file and secret_key_file query parameters,
+// this keeps the keys out of the environment
+func withCredentialFiles(u *url.URL) (string, error) {
+	query := u.Query()
+	keyFile, secretFile := query.Get("access_key_file"), query.Get("secret_key_file")
+	if keyFile == "" && secretFile == "" {
+		return u.String(), nil
+	}
 
-	for _, t := range f {
+	if keyFile == "" || secretFile == "" {
+		return "", errors.New("both access_key_file and secret_key_file are required")
+	}
+
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return "", fmt.Errorf("unable to read access key file: %w", err)
+	}
+
+	secret, err := os.ReadFile(secretFile)
+	if err != nil {
+		return "", fmt.Errorf("unable to read secret key file: %w", err)
+	}
+
+	query.Del("access_key_file")
+	query.Del("secret_key_file")
+
+	withCredentials := *u
+	withCredentials.User = url.UserPassword(strings.TrimSpace(string(key)), strings.TrimSpace(string(secret)))
+	withCredentials.RawQuery = query.Encode()
+
+	return withCredentials.String(), nil
+}
+
+// Writer writes the target to every destination, the destinations that
+// can't be opened are dropped as long as the write policy allows it
+func (f multifs) Writer(ctx context.Context, target string) (FileWriter, error) {
+	writers := &multiWriteCloser{fanout: fanout[FileWriter]{required: f.required(), stop: FileWriter.Abort}}
+
+	for _, t := range f.dsts {
 		file, err := t.Writer(ctx, target)
 		if err != nil {
-			defer writers.Close() //nolint: errcheck
+			writers.fail(t.name, fmt.Errorf("open: %w", err))
+			continue
+		}
 
-			return nil, err
+		writers.writers = append(writers.writers, named[FileWriter]{w: file, name: t.name})
+	}
+
+	if err := writers.check(); err != nil {
+		writers.Abort() //nolint: errcheck
+		return nil, err
+	}
+
+	return writers, nil
+}
+
+// Resume resumes the write in every destination, the state contains the
+// states of the destinations by name
+func (f multifs) Resume(ctx context.Context, target, state string) (ResumableWriter, error) {
+	states := map[string]string{}
+	if state != "" {
+		if err := json.Unmarshal([]byte(state), &states); err != nil {
+			return nil, fmt.Errorf("invalid resume state: %w", err)
 		}
+	}
 
-		writers = append(writers, file)
+	writers := &multiResumableWriter{fanout: fanout[ResumableWriter]{required: f.required(), stop: ResumableWriter.Pause}}
+	for _, t := range f.dsts {
+		resumer, ok := t.FileSystem.(Resumer)
+		if !ok {
+			writers.Pause() //nolint: errcheck
+			return nil, fmt.Errorf("%s can't resume writes", t.name)
+		}
+
+		w, err := resumer.Resume(ctx, target, states[t.name])
+		if err != nil {
+			writers.fail(t.name, fmt.Errorf("open: %w", err))
+			continue
+		}
+
+		writers.writers = append(writers.writers, named[ResumableWriter]{w: w, name: t.name})
+	}
+
+	if err := writers.check(); err != nil {
+		writers.Pause() //nolint: errcheck
+		return nil, err
 	}
 
 	return writers, nil
 }
 
+// Reader reads the target from the first destination that can open it
 func (f multifs) Reader(ctx context.Context, target string) (io.Reader, error) {
-	if len(f) < 1 {
+	if len(f.dsts) < 1 {
 		return nil, fmt.Errorf("no fs available")
 	}
 
-	return f[0].Reader(ctx, target)
+	var errs error
+	for _, t := range f.dsts {
+		rd, err := t.Reader(ctx, target)
+		if err == nil {
+			return rd, nil
+		}
+
+		errs = errors.Join(errs, fmt.Errorf("%s: %w", t.name, err))
+	}
+
+	return nil, errs
+}
+
+// Stat returns the info of the target if it exists in every destination
+// with the same size
+func (f multifs) Stat(ctx context.Context, target string) (FileInfo, error) {
+	if len(f.dsts) < 1 {
+		return FileInfo{}, fmt.Errorf("no fs available")
+	}
+
+	var info FileInfo
+	for i, t := range f.dsts {
+		fi, err := t.Stat(ctx, target)
+		if err != nil {
+			return FileInfo{}, fmt.Errorf("%s: %w", t.name, err)
+		}
+
+		if i > 0 && fi.Size != info.Size {
+			return FileInfo{}, fmt.Errorf("%s: size %d differs from %s: size %d", t.name, fi.Size, f.dsts[0].name, info.Size)
+		}
+
+		info = fi
+	}
+
+	return info, nil
+}
+
+// List returns the files with the prefix in any of the destinations
+func (f multifs) List(ctx context.Context, prefix string) ([]FileInfo, error) {
+	seen := map[string]bool{}
+	files := []FileInfo{}
+
+	for _, t := range f.dsts {
+		infos, err := t.List(ctx, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", t.name, err)
+		}
+
+		for _, info := range infos {
+			if !seen[info.Name] {
+				seen[info.Name] = true
+				files = append(files, info)
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// Delete removes the target from every destination
+func (f multifs) Delete(ctx context.Context, target string) error {
+	var errs error
+	for _, t := range f.dsts {
+		if err := t.Delete(ctx, target); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("%s: %w", t.name, err))
+		}
+	}
+
+	return errs
+}
+
+// Rename renames the target in every destination, the destinations that
+// don't have the target are still renamed and return fs.ErrNotExist
+func (f multifs) Rename(ctx context.Context, target, name string) error {
+	var errs error
+	for _, t := range f.dsts {
+		if err := t.Rename(ctx, target, name); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("%s: %w", t.name, err))
+		}
+	}
+
+	return errs
+}
+
+// Copy copies the target in every destination, the destinations that don't
+// have the target are still copied and return fs.ErrNotExist
+func (f multifs) Copy(ctx context.Context, target, name string) error {
+	var errs error
+	for _, t := range f.dsts {
+		if err := t.Copy(ctx, target, name); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("%s: %w", t.name, err))
+		}
+	}
+
+	return errs
+}
+
+// destinations returns the destinations of the file system
+func destinations(f FileSystem) []destination {
+	if m, ok := f.(multifs); ok {
+		return m.dsts
+	}
+
+	return []destination{{FileSystem: f, name: "destination"}}
 }
 
 type osfs struct {
 	base string
 }
 
+// newOsFS returns the file system of the directory, the directory is
+// created by the first write so opening it doesn't change anything
 func newOsFS(base string) (*osfs, error) {
-	err := os.MkdirAll(base, os.ModePerm)
-	if err != nil {
-		return nil, err
+	if info, err := os.Stat(base); err == nil && !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", base)
 	}
 
 	return &osfs{
@@ -97,59 +369,796 @@ func newOsFS(base string) (*osfs, error) {
 	}, nil
 }
 
-func (f *osfs) Writer(_ context.Context, target string) (io.WriteCloser, error) {
+// Writer writes the target to a temporary file in the same directory, which
+// replaces the target when it's committed
+func (f *osfs) Writer(_ context.Context, target string) (FileWriter, error) {
 	target = path.Join(f.base, target)
 	err := os.MkdirAll(path.Dir(target), os.ModePerm)
 	if err != nil {
 		return nil, err
 	}
 
-	return os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	file, err := os.CreateTemp(path.Dir(target), "."+path.Base(target)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := file.Chmod(0o644); err != nil {
+		file.Close()           //nolint: errcheck
+		os.Remove(file.Name()) //nolint: errcheck
+
+		return nil, err
+	}
+
+	return &osFileWriter{File: file, target: target}, nil
 }
 
-func (f *osfs) Reader(_ context.Context, target string) (io.Reader, error) {
+// partialSuffix is the suffix of the files that are still being written
+const partialSuffix = ".part"
+
+// Resume continues writing the partial file of the target, which is renamed
+// to the target when it's completed
+func (f *osfs) Resume(_ context.Context, target, _ string) (ResumableWriter, error) {
 	target = path.Join(f.base, target)
-	err := os.MkdirAll(path.Dir(target), os.ModePerm)
+	if err := os.MkdirAll(path.Dir(target), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(target+partialSuffix, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	offset, err := file.Seek(0, io.SeekEnd)
 	if err != nil {
+		file.Close() //nolint: errcheck
 		return nil, err
 	}
 
-	return os.OpenFile(target, os.O_CREATE|os.O_RDONLY, 0o644)
+	return &osResumableWriter{file: file, target: target, offset: offset}, nil
 }
 
-type s3fs struct {
+func (f *osfs) Reader(_ context.Context, target string) (io.Reader, error) {
+	return os.Open(path.Join(f.base, target))
+}
+
+func (f *osfs) Stat(_ context.Context, target string) (FileInfo, error) {
+	fi, err := os.Stat(path.Join(f.base, target))
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	return FileInfo{Name: target, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+// List returns the files with the prefix, the names are relative to the base
+func (f *osfs) List(_ context.Context, prefix string) ([]FileInfo, error) {
+	files := []FileInfo{}
+
+	err := filepath.WalkDir(f.base, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(f.base, p)
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		name = filepath.ToSlash(name)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		files = append(files, FileInfo{Name: name, Size: info.Size(), ModTime: info.ModTime()})
+
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return files, nil
+	}
+
+	return files, err
+}
+
+func (f *osfs) Delete(_ context.Context, target string) error {
+	err := os.Remove(path.Join(f.base, target))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+func (f *osfs) Rename(_ context.Context, target, name string) error {
+	name = path.Join(f.base, name)
+	if err := os.MkdirAll(path.Dir(name), os.ModePerm); err != nil {
+		return err
+	}
+
+	return os.Rename(path.Join(f.base, target), name)
+}
+
+// Copy copies the target with an atomic write so the copy is either
+// complete or doesn't exist
+func (f *osfs) Copy(ctx context.Context, target, name string) error {
+	src, err := os.Open(path.Join(f.base, target))
+	if err != nil {
+		return err
+	}
+	defer src.Close() //nolint: errcheck
+
+	dst, err := f.Writer(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return errors.Join(err, dst.Abort())
+	}
+
+	return dst.Close()
+}
+
+// s3Bucket is the bucket of an s3 destination
+type s3Bucket interface {
+	Put(ctx context.Context, key string) io.WriteCloser
+	Get(ctx context.Context, key string) io.Reader
+	Client() *s3.Client
+	Name() string
+}
+
+// s3ioBucket uploads and downloads the objects concurrently with s3io
+type s3ioBucket struct {
 	bucket s3io.Bucket
 }
 
-func (f *s3fs) Writer(ctx context.Context, target string) (io.WriteCloser, error) {
-	return f.bucket.Put(ctx, target), nil
+func (b s3ioBucket) Put(ctx context.Context, key string) io.WriteCloser {
+	return b.bucket.Put(ctx, key)
+}
+
+func (b s3ioBucket) Get(ctx context.Context, key string) io.Reader {
+	return b.bucket.Get(ctx, key)
+}
+
+func (b s3ioBucket) Client() *s3.Client {
+	return b.bucket.Client()
+}
+
+func (b s3ioBucket) Name() string {
+	return b.bucket.Name()
+}
+
+type s3fs struct {
+	bucket   s3Bucket
+	partSize int64
+}
+
+// Writer uploads the target, the object is only created when the upload is
+// completed so an aborted upload is canceled
+func (f *s3fs) Writer(ctx context.Context, target string) (FileWriter, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &s3FileWriter{WriteCloser: f.bucket.Put(ctx, target), cancel: cancel}, nil
+}
+
+// Resume continues the multipart upload of the state, a new upload is
+// started when it doesn't exist anymore (e.g. aborted by a lifecycle rule)
+func (f *s3fs) Resume(ctx context.Context, target, state string) (ResumableWriter, error) {
+	w := &s3MultipartWriter{
+		ctx:      ctx,
+		client:   f.bucket.Client(),
+		bucket:   f.bucket.Name(),
+		key:      target,
+		partSize: f.partSize,
+	}
+
+	if state != "" {
+		if parts, err := w.listParts(state); err == nil {
+			w.uploadID, w.parts = state, parts
+			return w, nil
+		}
+	}
+
+	out, err := w.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(w.bucket),
+		Key:    aws.String(target),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	w.uploadID = aws.ToString(out.UploadId)
+
+	return w, nil
 }
 
+// Reader downloads the object, its existence is checked first since the
+// download only starts on the first read
 func (f *s3fs) Reader(ctx context.Context, target string) (io.Reader, error) {
+	if _, err := f.Stat(ctx, target); err != nil {
+		return nil, err
+	}
+
 	return f.bucket.Get(ctx, target), nil
 }
 
-type multiWriteCloser []io.WriteCloser
+func (f *s3fs) Stat(ctx context.Context, target string) (FileInfo, error) {
+	out, err := f.bucket.Client().HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(f.bucket.Name()),
+		Key:    aws.String(target),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return FileInfo{}, fmt.Errorf("%s: %w", target, fs.ErrNotExist)
+		}
+
+		return FileInfo{}, err
+	}
 
-func (t multiWriteCloser) Close() error {
-	var err error
-	for _, c := range t {
-		err = errors.Join(c.Close())
+	return FileInfo{
+		Name:    target,
+		Size:    aws.ToInt64(out.ContentLength),
+		ModTime: aws.ToTime(out.LastModified),
+	}, nil
+}
+
+// List returns the objects with the prefix
+func (f *s3fs) List(ctx context.Context, prefix string) ([]FileInfo, error) {
+	files := []FileInfo{}
+
+	paginator := s3.NewListObjectsV2Paginator(f.bucket.Client(), &s3.ListObjectsV2Input{
+		Bucket: aws.String(f.bucket.Name()),
+		Prefix: aws.String(prefix),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range page.Contents {
+			files = append(files, FileInfo{
+				Name:    aws.ToString(obj.Key),
+				Size:    aws.ToInt64(obj.Size),
+				ModTime: aws.ToTime(obj.LastModified),
+			})
+		}
 	}
 
+	return files, nil
+}
+
+// Delete removes the object, S3 doesn't return an error for a missing object
+func (f *s3fs) Delete(ctx context.Context, target string) error {
+	_, err := f.bucket.Client().DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(f.bucket.Name()),
+		Key:    aws.String(target),
+	})
+
 	return err
 }
 
-func (t multiWriteCloser) Write(p []byte) (n int, err error) {
-	for _, w := range t {
-		n, err = w.Write(p)
+// Rename copies the object to the new key and deletes the original, S3
+// can't move objects
+func (f *s3fs) Rename(ctx context.Context, target, name string) error {
+	if err := f.Copy(ctx, target, name); err != nil {
+		return err
+	}
+
+	return f.Delete(ctx, target)
+}
+
+// maxCopySize is the largest object S3 can copy in a single request
+const maxCopySize = 5 * 1024 * 1024 * 1024
+
+// Copy copies the object within the bucket, larger objects than S3 can copy
+// at once are copied with a multipart upload
+func (f *s3fs) Copy(ctx context.Context, target, name string) error {
+	info, err := f.Stat(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	source := (&url.URL{Path: f.bucket.Name() + "/" + target}).EscapedPath()
+	if info.Size > maxCopySize {
+		return f.multipartCopy(ctx, source, name, info.Size)
+	}
+
+	_, err = f.bucket.Client().CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(f.bucket.Name()),
+		Key:        aws.String(name),
+		CopySource: aws.String(source),
+	})
+
+	return err
+}
+
+// multipartCopy copies the source in parts, the part size is raised to stay
+// within the 10000 parts of a multipart upload
+func (f *s3fs) multipartCopy(ctx context.Context, source, name string, size int64) error {
+	client := f.bucket.Client()
+	partSize := max(f.partSize, (size+9999)/10000)
+
+	out, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(f.bucket.Name()),
+		Key:    aws.String(name),
+	})
+	if err != nil {
+		return err
+	}
+
+	w := &s3MultipartWriter{
+		ctx:      ctx,
+		client:   client,
+		bucket:   f.bucket.Name(),
+		key:      name,
+		uploadID: aws.ToString(out.UploadId),
+		partSize: partSize,
+	}
+
+	for start := int64(0); start < size; start += partSize {
+		number := int32(len(w.parts) + 1)
+
+		part, err := client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(w.bucket),
+			Key:             aws.String(name),
+			UploadId:        aws.String(w.uploadID),
+			PartNumber:      aws.Int32(number),
+			CopySource:      aws.String(source),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, min(start+partSize, size)-1)),
+		})
 		if err != nil {
-			return
+			return errors.Join(err, w.Abort())
 		}
-		if n != len(p) {
+
+		w.parts = append(w.parts, types.CompletedPart{ETag: part.CopyPartResult.ETag, PartNumber: aws.Int32(number)})
+	}
+
+	if err := w.Close(); err != nil {
+		return errors.Join(err, w.Abort())
+	}
+
+	return nil
+}
+
+// osFileWriter writes to the temporary file of the target
+type osFileWriter struct {
+	*os.File
+	target string
+}
+
+func (w *osFileWriter) Close() error {
+	if err := errors.Join(w.File.Sync(), w.File.Close()); err != nil {
+		os.Remove(w.File.Name()) //nolint: errcheck
+		return err
+	}
+
+	return os.Rename(w.File.Name(), w.target)
+}
+
+func (w *osFileWriter) Abort() error {
+	w.File.Close() //nolint: errcheck
+
+	return os.Remove(w.File.Name())
+}
+
+// s3FileWriter is an upload that is canceled when it's aborted
+type s3FileWriter struct {
+	io.WriteCloser
+	cancel context.CancelFunc
+}
+
+func (w *s3FileWriter) Close() error {
+	defer w.cancel()
+
+	return w.WriteCloser.Close()
+}
+
+func (w *s3FileWriter) Abort() error {
+	w.cancel()
+	w.WriteCloser.Close() //nolint: errcheck
+
+	return nil
+}
+
+// osResumableWriter writes to the partial file of the target
+type osResumableWriter struct {
+	file   *os.File
+	target string
+	offset int64
+}
+
+func (w *osResumableWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.offset += int64(n)
+
+	return n, err
+}
+
+func (w *osResumableWriter) Offset() int64 {
+	return w.offset
+}
+
+func (w *osResumableWriter) Rewind(offset int64) (int64, error) {
+	offset = max(min(offset, w.offset), 0)
+	if err := w.file.Truncate(offset); err != nil {
+		return 0, err
+	}
+
+	if _, err := w.file.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	w.offset = offset
+
+	return offset, nil
+}
+
+func (w *osResumableWriter) State() string {
+	return ""
+}
+
+func (w *osResumableWriter) Pause() error {
+	return errors.Join(w.file.Sync(), w.file.Close())
+}
+
+func (w *osResumableWriter) Abort() error {
+	w.file.Close() //nolint: errcheck
+
+	return os.Remove(w.target + partialSuffix)
+}
+
+func (w *osResumableWriter) Close() error {
+	if err := w.Pause(); err != nil {
+		return err
+	}
+
+	return os.Rename(w.target+partialSuffix, w.target)
+}
+
+// s3MultipartWriter writes the target with a multipart upload, the uploaded
+// parts are kept when it's paused so the upload can be resumed
+type s3MultipartWriter struct {
+	ctx      context.Context
+	client   *s3.Client
+	bucket   string
+	key      string
+	uploadID string
+	partSize int64
+	parts    []types.CompletedPart
+	buf      bytes.Buffer
+}
+
+func (w *s3MultipartWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+
+	for int64(w.buf.Len()) >= w.partSize {
+		if err := w.uploadPart(w.buf.Next(int(w.partSize))); err != nil {
+			return len(p), err
+		}
+	}
+
+	return len(p), nil
+}
+
+func (w *s3MultipartWriter) uploadPart(data []byte) error {
+	number := int32(len(w.parts) + 1)
+
+	out, err := w.client.UploadPart(w.ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(w.bucket),
+		Key:        aws.String(w.key),
+		UploadId:   aws.String(w.uploadID),
+		PartNumber: aws.Int32(number),
+		Body:       bytes.NewReader(data),
+	})
+	if err != nil {
+		return err
+	}
+
+	w.parts = append(w.parts, types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(number)})
+
+	return nil
+}
+
+// listParts returns the leading parts of the upload with the part size
+func (w *s3MultipartWriter) listParts(uploadID string) ([]types.CompletedPart, error) {
+	parts := []types.CompletedPart{}
+
+	paginator := s3.NewListPartsPaginator(w.client, &s3.ListPartsInput{
+		Bucket:   aws.String(w.bucket),
+		Key:      aws.String(w.key),
+		UploadId: aws.String(uploadID),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(w.ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, part := range page.Parts {
+			if aws.ToInt32(part.PartNumber) != int32(len(parts)+1) || aws.ToInt64(part.Size) != w.partSize {
+				return parts, nil
+			}
+
+			parts = append(parts, types.CompletedPart{ETag: part.ETag, PartNumber: part.PartNumber})
+		}
+	}
+
+	return parts, nil
+}
+
+func (w *s3MultipartWriter) Offset() int64 {
+	return int64(len(w.parts)) * w.partSize
+}
+
+func (w *s3MultipartWriter) Rewind(offset int64) (int64, error) {
+	w.parts = w.parts[:min(max(offset, 0)/w.partSize, int64(len(w.parts)))]
+	w.buf.Reset()
+
+	return w.Offset(), nil
+}
+
+func (w *s3MultipartWriter) State() string {
+	return w.uploadID
+}
+
+func (w *s3MultipartWriter) Pause() error {
+	w.buf.Reset()
+	return nil
+}
+
+func (w *s3MultipartWriter) Abort() error {
+	w.buf.Reset()
+
+	_, err := w.client.AbortMultipartUpload(w.ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(w.bucket),
+		Key:      aws.String(w.key),
+		UploadId: aws.String(w.uploadID),
+	})
+
+	return err
+}
+
+func (w *s3MultipartWriter) Close() error {
+	if w.buf.Len() > 0 || len(w.parts) == 0 {
+		if err := w.uploadPart(w.buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.client.CompleteMultipartUpload(w.ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(w.bucket),
+		Key:             aws.String(w.key),
+		UploadId:        aws.String(w.uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: w.parts},
+	})
+
+	return err
+}
+
+// PartialWriter is implemented by the writers that continue when some of
+// the destinations fail
+type PartialWriter interface {
+	// Failed returns the errors of the destinations that were dropped
+	Failed() map[string]error
+}
+
+// named is a writer of a destination
+type named[W FileWriter] struct {
+	w    W
+	name string
+}
+
+// fanout contains the writers of the destinations that didn't fail yet,
+// the write fails when less than the required writers are left
+type fanout[W FileWriter] struct {
+	writers  []named[W]
+	dropped  []named[W]
+	required int
+	failed   map[string]error
+	stop     func(W) error // stops the writer of a failed destination
+}
+
+// fail drops the destination from the fan-out
+func (f *fanout[W]) fail(name string, err error) {
+	if f.failed == nil {
+		f.failed = map[string]error{}
+	}
+
+	f.failed[name] = err
+
+	i := slices.IndexFunc(f.writers, func(w named[W]) bool { return w.name == name })
+	if i >= 0 {
+		f.stop(f.writers[i].w) //nolint: errcheck
+		f.dropped = append(f.dropped, f.writers[i])
+		f.writers = slices.Delete(f.writers, i, i+1)
+	}
+}
+
+// check returns the errors of the failed destinations when not enough
+// destinations are left
+func (f *fanout[W]) check() error {
+	if len(f.writers) >= f.required {
+		return nil
+	}
+
+	var errs error
+	for name, err := range f.failed {
+		errs = errors.Join(errs, fmt.Errorf("%s: %w", name, err))
+	}
+
+	return cmp.Or(errs, errors.New("no destination available"))
+}
+
+// write writes to every writer, the failing writers are dropped
+func (f *fanout[W]) write(p []byte) (int, error) {
+	for _, w := range slices.Clone(f.writers) {
+		n, err := w.w.Write(p)
+		if err == nil && n != len(p) {
 			err = io.ErrShortWrite
-			return
+		}
+
+		if err != nil {
+			f.fail(w.name, err)
 		}
 	}
+
+	if err := f.check(); err != nil {
+		return 0, err
+	}
+
 	return len(p), nil
 }
+
+// close commits the file in every writer, the destinations that can't
+// commit the file are dropped
+func (f *fanout[W]) close() error {
+	for _, w := range slices.Clone(f.writers) {
+		if err := w.w.Close(); err != nil {
+			f.fail(w.name, err)
+		}
+	}
+
+	return f.check()
+}
+
+func (f *fanout[W]) abort() error {
+	var errs error
+	for _, w := range f.writers {
+		if err := w.w.Abort(); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("%s: %w", w.name, err))
+		}
+	}
+
+	return errs
+}
+
+func (f *fanout[W]) Failed() map[string]error {
+	return f.failed
+}
+
+// multiResumableWriter writes to the resumable writers of every destination,
+// the writers are rewound to the lowest offset so they continue together
+type multiResumableWriter struct {
+	fanout[ResumableWriter]
+}
+
+func (t *multiResumableWriter) Write(p []byte) (int, error) {
+	return t.write(p)
+}
+
+func (t *multiResumableWriter) Offset() int64 {
+	if len(t.writers) == 0 {
+		return 0
+	}
+
+	offset := t.writers[0].w.Offset()
+	for _, w := range t.writers[1:] {
+		offset = min(offset, w.w.Offset())
+	}
+
+	return offset
+}
+
+func (t *multiResumableWriter) Rewind(offset int64) (int64, error) {
+	// every round lowers the offset to the one all writers can continue from
+	for {
+		lowest := offset
+		for _, w := range slices.Clone(t.writers) {
+			actual, err := w.w.Rewind(offset)
+			if err != nil {
+				t.fail(w.name, err)
+				continue
+			}
+
+			lowest = min(lowest, actual)
+		}
+
+		if err := t.check(); err != nil {
+			return 0, err
+		}
+
+		if lowest == offset {
+			return offset, nil
+		}
+
+		offset = lowest
+	}
+}
+
+// State returns the states of the writers, including the ones that were
+// dropped so they can be resumed as well
+func (t *multiResumableWriter) State() string {
+	states := make(map[string]string, len(t.writers))
+	for _, w := range slices.Concat(t.writers, t.dropped) {
+		if state := w.w.State(); state != "" {
+			states[w.name] = state
+		}
+	}
+
+	if len(states) == 0 {
+		return ""
+	}
+
+	state, _ := json.Marshal(states) //nolint: errcheck
+	return string(state)
+}
+
+func (t *multiResumableWriter) Pause() error {
+	var err error
+	for _, w := range t.writers {
+		err = errors.Join(err, w.w.Pause())
+	}
+
+	return err
+}
+
+func (t *multiResumableWriter) Abort() error {
+	t.abortDropped()
+	return t.abort()
+}
+
+// Close completes the file, the partial files of the destinations that were
+// dropped are removed since the file has to be repaired there
+func (t *multiResumableWriter) Close() error {
+	if err := t.close(); err != nil {
+		return err
+	}
+
+	t.abortDropped()
+
+	return nil
+}
+
+func (t *multiResumableWriter) abortDropped() {
+	for _, w := range t.dropped {
+		w.w.Abort() //nolint: errcheck
+	}
+}
+
+// multiWriteCloser writes to every destination, the file is committed in
+// every destination on Close
+type multiWriteCloser struct {
+	fanout[FileWriter]
+}
+
+func (t *multiWriteCloser) Write(p []byte) (int, error) {
+	return t.write(p)
+}
+
+func (t *multiWriteCloser) Abort() error {
+	return t.abort()
+}
+
+func (t *multiWriteCloser) Close() error {
+	return t.close()
+}
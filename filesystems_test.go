@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestWithCredentialFiles(t *testing.T) {
+	dir := t.TempDir()
+	keyFile, secretFile := path.Join(dir, "key"), path.Join(dir, "secret")
+	_ = os.WriteFile(keyFile, []byte("access-key\n"), 0o600)
+	_ = os.WriteFile(secretFile, []byte("secret-key\n"), 0o600)
+
+	u, _ := url.Parse("s3://minio:9000/recordings?region=us-east&access_key_file=" + keyFile + "&secret_key_file=" + secretFile) //nolint: errcheck
+
+	dst, err := withCredentialFiles(u)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if e, a := "s3://access-key:secret-key@minio:9000/recordings?region=us-east", dst; e != a {
+		t.Errorf("expected %s but got %s", e, a)
+	}
+
+	u, _ = url.Parse("s3://minio:9000/recordings?access_key_file=" + keyFile) //nolint: errcheck
+	_, err = withCredentialFiles(u)
+	assert(t, err != nil, "missing secret key file must return an error")
+}
+
+func TestResumeWrite(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	first, _ := newOsFS(path.Join(dir, "first"))   //nolint: errcheck
+	second, _ := newOsFS(path.Join(dir, "second")) //nolint: errcheck
+	f := multifs{dsts: []destination{{FileSystem: first, name: "first"}, {FileSystem: second, name: "second"}}}
+
+	w, err := f.Resume(ctx, "file.txt", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, _ = w.Write([]byte("some ")) //nolint: errcheck
+	assert(t, w.Pause() == nil, "pause must not return an error")
+
+	// the second destination got more data before the failure
+	w2, _ := second.Resume(ctx, "file.txt", "") //nolint: errcheck
+	_, _ = w2.Write([]byte("ran"))              //nolint: errcheck
+	_ = w2.Pause()                              //nolint: errcheck
+
+	_, err = os.Stat(path.Join(dir, "first", "file.txt"))
+	assert(t, os.IsNotExist(err), "paused file must not be completed")
+
+	w, err = f.Resume(ctx, "file.txt", w.State())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	offset, err := w.Rewind(5)
+	assert(t, err == nil && offset == 5, "writers must continue from the common offset")
+
+	_, _ = w.Write([]byte("random file")) //nolint: errcheck
+	assert(t, w.Close() == nil, "close must not return an error")
+
+	for _, dst := range []string{"first", "second"} {
+		content, err := os.ReadFile(path.Join(dir, dst, "file.txt"))
+		assert(t, err == nil && string(content) == "some random file", "resumed file must be complete in", dst)
+	}
+}
+
+func TestAtomicWrite(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	first, _ := newOsFS(path.Join(dir, "first"))   //nolint: errcheck
+	second, _ := newOsFS(path.Join(dir, "second")) //nolint: errcheck
+	f := multifs{dsts: []destination{{FileSystem: first, name: "first"}, {FileSystem: second, name: "second"}}}
+
+	w, err := f.Writer(ctx, "dir/file.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, _ = w.Write([]byte("half a")) //nolint: errcheck
+	assert(t, w.Abort() == nil, "abort must not return an error")
+
+	for _, dst := range []string{"first", "second"} {
+		entries, err := os.ReadDir(path.Join(dir, dst, "dir"))
+		assert(t, err == nil && len(entries) == 0, "aborted file must be removed from", dst)
+	}
+
+	w, err = f.Writer(ctx, "dir/file.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, _ = w.Write([]byte("some random file")) //nolint: errcheck
+
+	_, err = os.Stat(path.Join(dir, "first", "dir", "file.txt"))
+	assert(t, os.IsNotExist(err), "file must not appear before it's committed")
+
+	assert(t, w.Close() == nil, "close must not return an error")
+
+	for _, dst := range []string{"first", "second"} {
+		content, err := os.ReadFile(path.Join(dir, dst, "dir", "file.txt"))
+		assert(t, err == nil && string(content) == "some random file", "committed file must be complete in", dst)
+
+		entries, _ := os.ReadDir(path.Join(dir, dst, "dir")) //nolint: errcheck
+		assert(t, len(entries) == 1, "temporary file must be renamed in", dst)
+	}
+}
+
+func TestFileSystem(t *testing.T) {
+	dir := t.TempDir()
+
+	local, _ := newOsFS(path.Join(dir, "local")) //nolint: errcheck
+	t.Run("os", func(t *testing.T) { testFileSystem(t, local) })
+
+	first, _ := newOsFS(path.Join(dir, "first"))   //nolint: errcheck
+	second, _ := newOsFS(path.Join(dir, "second")) //nolint: errcheck
+	multi := multifs{dsts: []destination{{FileSystem: first, name: "first"}, {FileSystem: second, name: "second"}}}
+	t.Run("multi", func(t *testing.T) { testFileSystem(t, multi) })
+
+	t.Run("s3", func(t *testing.T) {
+		mock := SetupS3Mock(t)
+		testFileSystem(t, &s3fs{bucket: mock.Bucket(), partSize: 5 * 1024 * 1024})
+	})
+
+	t.Run("multi s3", func(t *testing.T) {
+		mock := SetupS3Mock(t)
+		testFileSystem(t, multifs{dsts: []destination{
+			{FileSystem: local, name: "local"},
+			{FileSystem: &s3fs{bucket: mock.Bucket(), partSize: 5 * 1024 * 1024}, name: "s3"},
+		}})
+	})
+}
+
+func TestS3Resume(t *testing.T) {
+	ctx := context.Background()
+	mock := SetupS3Mock(t)
+	f := &s3fs{bucket: mock.Bucket(), partSize: 5}
+
+	w, err := f.Resume(ctx, "file.txt", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = w.Write([]byte("some random"))
+	assert(t, err == nil && w.Offset() == 10, "full parts must be uploaded")
+	assert(t, w.Pause() == nil, "pause must not return an error")
+
+	state := w.State()
+	_, err = f.Stat(ctx, "file.txt")
+	assert(t, errors.Is(err, fs.ErrNotExist), "paused upload must not create the file")
+
+	w, err = f.Resume(ctx, "file.txt", state)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assert(t, w.State() == state && w.Offset() == 10, "upload must continue after the uploaded parts")
+
+	offset, err := w.Rewind(7)
+	assert(t, err == nil && offset == 5, "rewind must return to the start of a part")
+
+	_, _ = w.Write([]byte("random file")) //nolint: errcheck
+	assert(t, w.Close() == nil, "close must not return an error")
+	assertContent(t, f, "file.txt", "some random file")
+
+	w, err = f.Resume(ctx, "other.txt", state)
+	assert(t, err == nil && w.State() != state && w.Offset() == 0, "completed upload must be started over")
+
+	_, _ = w.Write([]byte("some random")) //nolint: errcheck
+	_ = w.Pause()                         //nolint: errcheck
+
+	// a shorter part ends the parts that can be resumed
+	bigger := &s3fs{bucket: mock.Bucket(), partSize: 10}
+	w, err = bigger.Resume(ctx, "other.txt", w.State())
+	assert(t, err == nil && w.Offset() == 0, "parts of another size must not be resumed")
+	assert(t, w.Abort() == nil, "abort must not return an error")
+}
+
+func TestS3MultipartCopy(t *testing.T) {
+	ctx := context.Background()
+	mock := SetupS3Mock(t)
+	f := &s3fs{bucket: mock.Bucket(), partSize: 5}
+
+	w, _ := f.Writer(ctx, "dir/file.txt")      //nolint: errcheck
+	_, _ = w.Write([]byte("some random file")) //nolint: errcheck
+	assert(t, w.Close() == nil, "close must not return an error")
+
+	source := (&url.URL{Path: mock.bucket + "/dir/file.txt"}).EscapedPath()
+	assert(t, f.multipartCopy(ctx, source, "copy.txt", 16) == nil, "multipart copy must not return an error")
+	assertContent(t, f, "copy.txt", "some random file")
+
+	err := f.multipartCopy(ctx, source, "broken.txt", 20)
+	assert(t, err != nil, "multipart copy beyond the source must return an error")
+	assert(t, len(mock.uploads) == 0, "failed multipart copy must be aborted")
+
+	_, err = f.Stat(ctx, "broken.txt")
+	assert(t, errors.Is(err, fs.ErrNotExist), "failed multipart copy must not create the file")
+}
+
+// testFileSystem checks the behavior every FileSystem must have
+func testFileSystem(t *testing.T, f FileSystem) {
+	ctx := context.Background()
+	prefix := path.Join("test", t.Name()) + "/"
+	t.Cleanup(func() {
+		files, _ := f.List(ctx, prefix) //nolint: errcheck
+		for _, file := range files {
+			f.Delete(ctx, file.Name) //nolint: errcheck
+		}
+	})
+
+	content := "some random file"
+	w, err := f.Writer(ctx, prefix+"a/file.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, _ = w.Write([]byte(content)) //nolint: errcheck
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	info, err := f.Stat(ctx, prefix+"a/file.txt")
+	assert(t, err == nil && info.Size == int64(len(content)), "stat must return the size of the file")
+
+	_, err = f.Stat(ctx, prefix+"a/missing.txt")
+	assert(t, errors.Is(err, fs.ErrNotExist), "stat of a missing file must return fs.ErrNotExist")
+
+	assert(t, f.Copy(ctx, prefix+"a/file.txt", prefix+"b/copy.txt") == nil, "copy must not return an error")
+	assertContent(t, f, prefix+"b/copy.txt", content)
+	assertContent(t, f, prefix+"a/file.txt", content)
+
+	assert(t, f.Rename(ctx, prefix+"b/copy.txt", prefix+"c/renamed.txt") == nil, "rename must not return an error")
+	assertContent(t, f, prefix+"c/renamed.txt", content)
+
+	_, err = f.Stat(ctx, prefix+"b/copy.txt")
+	assert(t, errors.Is(err, fs.ErrNotExist), "renamed file must not exist anymore")
+
+	files, err := f.List(ctx, prefix+"c/")
+	assert(t, err == nil && len(files) == 1 && files[0].Name == prefix+"c/renamed.txt", "list must only return the files with the prefix")
+
+	assert(t, f.Delete(ctx, prefix+"a/file.txt") == nil, "delete must not return an error")
+	_, err = f.Stat(ctx, prefix+"a/file.txt")
+	assert(t, errors.Is(err, fs.ErrNotExist), "deleted file must not exist anymore")
+	assert(t, f.Delete(ctx, prefix+"a/file.txt") == nil, "deleting a missing file must not return an error")
+}
+
+func assertContent(t *testing.T, f FileSystem, target, expected string) {
+	t.Helper()
+
+	rd, err := f.Reader(context.Background(), target)
+	if err != nil {
+		t.Fatalf("unable to read %s: %v", target, err)
+	}
+
+	if closer, ok := rd.(io.Closer); ok {
+		defer closer.Close() //nolint: errcheck
+	}
+
+	content, err := io.ReadAll(rd)
+	assert(t, err == nil && string(content) == expected, "unexpected content of", target)
+}
+
+func TestMultiFSPartial(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	first, _ := newOsFS(path.Join(dir, "first"))   //nolint: errcheck
+	second, _ := newOsFS(path.Join(dir, "second")) //nolint: errcheck
+	f := multifs{dsts: []destination{{FileSystem: first, name: "first"}, {FileSystem: second, name: "second"}}}
+
+	_ = os.MkdirAll(path.Join(dir, "first"), os.ModePerm)                                    //nolint: errcheck
+	_ = os.WriteFile(path.Join(dir, "first", "file.txt"), []byte("some random file"), 0o600) //nolint: errcheck
+
+	_, err := f.Stat(ctx, "file.txt")
+	assert(t, errors.Is(err, fs.ErrNotExist), "file must exist in every destination")
+
+	files, err := f.List(ctx, "")
+	assert(t, err == nil && len(files) == 1, "list must return the files of any destination")
+
+	err = f.Rename(ctx, "file.txt", "renamed.txt")
+	assert(t, errors.Is(err, fs.ErrNotExist), "rename must report the destination without the file")
+
+	_, err = os.Stat(path.Join(dir, "first", "renamed.txt"))
+	assert(t, err == nil, "file must be renamed in the destinations that have it")
+
+	assert(t, f.Delete(ctx, "renamed.txt") == nil, "delete must not fail on the destinations without the file")
+
+	files, _ = f.List(ctx, "") //nolint: errcheck
+	assert(t, len(files) == 0, "file must be deleted from every destination")
+}
+
+// brokenfs is a destination that can't be written to
+type brokenfs struct {
+	*osfs
+}
+
+func (f brokenfs) Writer(ctx context.Context, target string) (FileWriter, error) {
+	w, err := f.osfs.Writer(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	return brokenWriter{w}, nil
+}
+
+func (f brokenfs) Resume(context.Context, string, string) (ResumableWriter, error) {
+	return nil, errors.New("destination unavailable")
+}
+
+func (f brokenfs) Reader(context.Context, string) (io.Reader, error) {
+	return nil, errors.New("destination unavailable")
+}
+
+type brokenWriter struct {
+	FileWriter
+}
+
+func (w brokenWriter) Write([]byte) (int, error) {
+	return 0, errors.New("destination unavailable")
+}
+
+func TestWritePolicy(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		policy string
+		broken int
+		ok     bool
+	}{
+		{"all", 0, true},
+		{"all", 1, false},
+		{"quorum", 1, true},
+		{"quorum", 2, false},
+		{"any", 2, true},
+		{"any", 3, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s with %d broken", tt.policy, tt.broken), func(t *testing.T) {
+			dir := t.TempDir()
+			f := multifs{policy: tt.policy}
+			for i := range 3 {
+				local, _ := newOsFS(path.Join(dir, fmt.Sprint(i))) //nolint: errcheck
+				dst := destination{FileSystem: local, name: fmt.Sprint(i)}
+				if i < tt.broken {
+					dst.FileSystem = brokenfs{local}
+				}
+
+				f.dsts = append(f.dsts, dst)
+			}
+
+			w, err := f.Writer(ctx, "file.txt")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			_, err = w.Write([]byte("some random file"))
+			if !tt.ok {
+				assert(t, err != nil, "write must fail without enough destinations")
+				assert(t, w.Abort() == nil, "abort must not return an error")
+				return
+			}
+
+			assert(t, err == nil, "write must continue without the broken destinations")
+			assert(t, w.Close() == nil, "close must not return an error")
+			assert(t, len(w.(PartialWriter).Failed()) == tt.broken, "broken destinations must be reported")
+
+			for i := range 3 {
+				_, err := os.Stat(path.Join(dir, fmt.Sprint(i), "file.txt"))
+				assert(t, (i >= tt.broken) == (err == nil), "file must only be written to the working destinations")
+			}
+		})
+	}
+}
+
+func TestMultiFSReader(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	first, _ := newOsFS(path.Join(dir, "first"))   //nolint: errcheck
+	second, _ := newOsFS(path.Join(dir, "second")) //nolint: errcheck
+	third, _ := newOsFS(path.Join(dir, "third"))   //nolint: errcheck
+	f := multifs{dsts: []destination{
+		{FileSystem: brokenfs{first}, name: "first"},
+		{FileSystem: second, name: "second"},
+		{FileSystem: third, name: "third"},
+	}}
+
+	_ = os.MkdirAll(path.Join(dir, "third"), os.ModePerm)                                    //nolint: errcheck
+	_ = os.WriteFile(path.Join(dir, "third", "file.txt"), []byte("some random file"), 0o600) //nolint: errcheck
+	assertContent(t, f, "file.txt", "some random file")
+
+	_, err := f.Reader(ctx, "missing.txt")
+	assert(t, err != nil, "reading a file that no destination has must fail")
+}
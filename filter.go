@@ -0,0 +1,325 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Matcher matches a value against a set of patterns, a pattern is an exact
+// value unless it starts with "glob:" (with *, ? and [...]) or "re:" (a
+// regular expression), empty patterns are ignored
+type Matcher struct {
+	exact    map[string]bool
+	patterns []*regexp.Regexp
+	fold     bool
+}
+
+// NewMatcher compiles the patterns, with fold the values are compared case
+// insensitive
+func NewMatcher(patterns []string, fold bool) (*Matcher, error) {
+	m := &Matcher{exact: map[string]bool{}, fold: fold}
+
+	var errs error
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+
+		expr := ""
+		if glob, ok := strings.CutPrefix(pattern, "glob:"); ok {
+			expr = globExpr(glob)
+		} else if re, ok := strings.CutPrefix(pattern, "re:"); ok {
+			expr = re
+		} else {
+			m.exact[m.key(pattern)] = true
+			continue
+		}
+
+		if fold {
+			expr = "(?i)" + expr
+		}
+
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("invalid pattern '%s': %w", pattern, err))
+			continue
+		}
+
+		m.patterns = append(m.patterns, re)
+	}
+
+	return m, errs
+}
+
+// Match reports if the value matches one of the patterns
+func (m *Matcher) Match(value string) bool {
+	if m.exact[m.key(value)] {
+		return true
+	}
+
+	for _, re := range m.patterns {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Empty reports if the matcher has no patterns
+func (m *Matcher) Empty() bool {
+	return len(m.exact) == 0 && len(m.patterns) == 0
+}
+
+func (m *Matcher) key(value string) string {
+	if m.fold {
+		return strings.ToLower(value)
+	}
+
+	return value
+}
+
+// globExpr returns the regular expression of the glob pattern, unlike
+// path.Match the wildcards match slashes since titles aren't paths
+func globExpr(glob string) string {
+	var expr strings.Builder
+	expr.WriteString("^")
+
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+
+			expr.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				expr.WriteString(`\[`)
+				continue
+			}
+
+			class := glob[i+1 : i+1+end]
+			if negated, ok := strings.CutPrefix(class, "!"); ok {
+				class = "^" + negated
+			}
+
+			expr.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	expr.WriteString("$")
+
+	return expr.String()
+}
+
+var (
+	// meetingFields are the fields of a meeting that rules can match
+	meetingFields = []string{"topic", "id", "host", "user"}
+	// fileFields are the fields rules can match for a recording file
+	fileFields = []string{"topic", "id", "host", "user", "type"}
+)
+
+// Rule matches meetings on their fields, a rule is a list of conditions
+// (<field>=<pattern>) combined with && and ||, where && binds stronger.
+// The id matches the meeting id and uuid, the host the email of the host
+// (case insensitive), the user the user id of the host and the type the
+// recording type of a recording file
+type Rule struct {
+	Source string
+	any    [][]condition
+}
+
+type condition struct {
+	field   string
+	matcher *Matcher
+}
+
+// ParseRule parses the rule with conditions on the given fields,
+// e.g. "topic=re:^Board && host=glob:*@legal.example.com"
+func ParseRule(rule string, fields []string) (*Rule, error) {
+	r := &Rule{Source: rule}
+
+	for alternative := range strings.SplitSeq(rule, "||") {
+		all := []condition{}
+		for cond := range strings.SplitSeq(alternative, "&&") {
+			field, pattern, ok := strings.Cut(strings.TrimSpace(cond), "=")
+			field, pattern = strings.ToLower(strings.TrimSpace(field)), strings.TrimSpace(pattern)
+			if !ok || field == "" || pattern == "" {
+				return nil, fmt.Errorf("invalid rule '%s': condition '%s' must be <field>=<pattern>", rule, strings.TrimSpace(cond))
+			}
+
+			if !slices.Contains(fields, field) {
+				return nil, fmt.Errorf("invalid rule '%s': unknown field '%s', use %s", rule, field, strings.Join(fields, ", "))
+			}
+
+			m, err := NewMatcher([]string{pattern}, field == "host")
+			if err != nil {
+				return nil, fmt.Errorf("invalid rule '%s': %w", rule, err)
+			}
+
+			all = append(all, condition{field: field, matcher: m})
+		}
+
+		r.any = append(r.any, all)
+	}
+
+	return r, nil
+}
+
+// Match reports if the meeting matches the rule
+func (r *Rule) Match(m Meeting) bool {
+	return r.MatchFile(m, RecordingFile{})
+}
+
+// MatchFile reports if the recording file of the meeting matches the rule
+func (r *Rule) MatchFile(m Meeting, rf RecordingFile) bool {
+	return slices.ContainsFunc(r.any, func(all []condition) bool {
+		for _, cond := range all {
+			if !cond.match(m, rf) {
+				return false
+			}
+		}
+
+		return true
+	})
+}
+
+func (c condition) match(m Meeting, rf RecordingFile) bool {
+	switch c.field {
+	case "topic":
+		return c.matcher.Match(m.Topic)
+	case "id":
+		return c.matcher.Match(strconv.Itoa(m.ID)) || c.matcher.Match(m.UUID)
+	case "host":
+		return c.matcher.Match(m.HostEmail)
+	case "user":
+		return c.matcher.Match(m.HostID)
+	case "type":
+		return c.matcher.Match(string(rf.RecordingType))
+	default:
+		return false
+	}
+}
+
+// Filters selects the users, meetings and recording files that are swept
+type Filters struct {
+	RecordingTypes *Matcher
+	IgnoreTitles   *Matcher
+	IncludeUsers   *Matcher
+	ExcludeUsers   *Matcher
+	IncludeRules   []*Rule
+	ExcludeRules   []*Rule
+}
+
+// Filters compiles the filters of the config
+func (c *Config) Filters() (*Filters, error) {
+	var errs error
+	compile := func(name string, patterns []string, fold bool) *Matcher {
+		m, err := NewMatcher(patterns, fold)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("%s: %w", name, err))
+		}
+
+		return m
+	}
+
+	parse := func(name string, rules []string) []*Rule {
+		parsed := []*Rule{}
+		for _, rule := range rules {
+			if strings.TrimSpace(rule) == "" {
+				continue
+			}
+
+			r, err := ParseRule(rule, meetingFields)
+			if err != nil {
+				errs = errors.Join(errs, fmt.Errorf("%s: %w", name, err))
+				continue
+			}
+
+			parsed = append(parsed, r)
+		}
+
+		return parsed
+	}
+
+	f := &Filters{
+		RecordingTypes: compile("recording_types", c.RecordingTypes, false),
+		IgnoreTitles:   compile("ignore_titles", c.IgnoreTitles, false),
+		IncludeUsers:   compile("include_users", c.IncludeUsers, true),
+		ExcludeUsers:   compile("exclude_users", c.ExcludeUsers, true),
+		IncludeRules:   parse("include_rules", c.IncludeRules),
+		ExcludeRules:   parse("exclude_rules", c.ExcludeRules),
+	}
+
+	return f, errs
+}
+
+// AllowedFile reports if the recording file is of an allowed recording type
+func (f *Filters) AllowedFile(rf RecordingFile) bool {
+	return rf.FileExtension != "" &&
+		rf.RecordingType != "" &&
+		f.RecordingTypes.Match(string(rf.RecordingType))
+}
+
+// AllowedFiles returns the recording files of an allowed recording type
+func (f *Filters) AllowedFiles(files []RecordingFile) []RecordingFile {
+	allowed := []RecordingFile{}
+	for _, rf := range files {
+		if f.AllowedFile(rf) {
+			allowed = append(allowed, rf)
+		}
+	}
+
+	return allowed
+}
+
+// IgnoredTitle reports if the meetings with the title are ignored
+func (f *Filters) IgnoredTitle(topic string) bool {
+	return f.IgnoreTitles.Match(topic)
+}
+
+// IncludedMeeting reports if the meeting is swept and why, a meeting is
+// skipped when its title is ignored or an exclude rule matches, otherwise
+// it's swept when there are no include rules or an include rule matches
+func (f *Filters) IncludedMeeting(m Meeting) (bool, string) {
+	if f.IgnoredTitle(m.Topic) {
+		return false, "the title is ignored"
+	}
+
+	for i, rule := range f.ExcludeRules {
+		if rule.Match(m) {
+			return false, fmt.Sprintf("exclude rule %d '%s' matches", i+1, rule.Source)
+		}
+	}
+
+	if len(f.IncludeRules) == 0 {
+		return true, "there are no include rules"
+	}
+
+	for i, rule := range f.IncludeRules {
+		if rule.Match(m) {
+			return true, fmt.Sprintf("include rule %d '%s' matches", i+1, rule.Source)
+		}
+	}
+
+	return false, "no include rule matches"
+}
+
+// AllowedUser reports if the user is included (or no users are included)
+// and not excluded, emails are compared case insensitive
+func (f *Filters) AllowedUser(email string) bool {
+	return (f.IncludeUsers.Empty() || f.IncludeUsers.Match(email)) && !f.ExcludeUsers.Match(email)
+}
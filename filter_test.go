@@ -0,0 +1,139 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMatcher(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		patterns []string
+		fold     bool
+		value    string
+		expected bool
+	}{
+		{"exact", []string{"shared_screen"}, false, "shared_screen", true},
+		{"exact is not a prefix", []string{"shared_screen"}, false, "shared_screen_with_speaker_view", false},
+		{"exact is not a substring", []string{"shared_screen_with_speaker_view"}, false, "speaker_view", false},
+		{"values are not joined", []string{"gallery", "view"}, false, "gallery view", false},
+		{"empty pattern matches nothing", []string{""}, false, "any title", false},
+		{"empty pattern doesn't match empty value", []string{""}, false, "", false},
+		{"no patterns", nil, false, "", false},
+		{"case sensitive", []string{"Standup"}, false, "standup", false},
+		{"fold", []string{"Me@Example.com"}, true, "me@example.COM", true},
+		{"glob", []string{"glob:Weekly *"}, false, "Weekly sync", true},
+		{"glob is anchored", []string{"glob:Weekly *"}, false, "Our Weekly sync", false},
+		{"glob matches slashes", []string{"glob:*sync*"}, false, "HR/Finance sync", true},
+		{"glob single character", []string{"glob:Q? review"}, false, "Q3 review", true},
+		{"glob class", []string{"glob:Q[1-2] review"}, false, "Q3 review", false},
+		{"glob negated class", []string{"glob:Q[!1-2] review"}, false, "Q3 review", true},
+		{"glob escaped wildcard", []string{`glob:what\?`}, false, "whats", false},
+		{"glob meta characters", []string{"glob:1+1 (*)"}, false, "1+1 (math)", true},
+		{"regex", []string{"re:^Board"}, false, "Board meeting", true},
+		{"regex is not anchored", []string{"re:meeting"}, false, "Board meeting 2024", true},
+		{"regex fold", []string{"re:^board"}, true, "Board meeting", true},
+		{"exact prefix is literal", []string{"Weekly *"}, false, "Weekly sync", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := NewMatcher(tc.patterns, tc.fold)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if m.Match(tc.value) != tc.expected {
+				t.Errorf("expected match %t for '%s'", tc.expected, tc.value)
+			}
+		})
+	}
+
+	_, err := NewMatcher([]string{"re:(unclosed"}, false)
+	assert(t, err != nil, "invalid regular expression must return an error")
+}
+
+func TestFilters(t *testing.T) {
+	filters, err := (&Config{
+		RecordingTypes: []string{string(RecordingTypeSharedScreen)},
+		IgnoreTitles:   []string{"", "ignore"},
+		IncludeUsers:   []string{""},
+		ExcludeUsers:   []string{"Skip@example.com"},
+	}).Filters()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	files := filters.AllowedFiles([]RecordingFile{
+		{RecordingType: RecordingTypeSharedScreen, FileExtension: "MP4"},
+		{RecordingType: RecordingTypeScharedScreenWithSpeaker, FileExtension: "MP4"},
+		{RecordingType: RecordingTypeSharedScreen},
+	})
+
+	assert(t, len(files) == 1 && files[0].RecordingType == RecordingTypeSharedScreen, "only the exact recording type must be allowed")
+	assert(t, !filters.IgnoredTitle("any title"), "empty ignored title must not ignore every meeting")
+	assert(t, !filters.IgnoredTitle("ign"), "ignored title must not match a part of the title")
+	assert(t, filters.IgnoredTitle("ignore"), "ignored title must be ignored")
+	assert(t, filters.AllowedUser("me@example.com"), "every user must be included without included users")
+	assert(t, !filters.AllowedUser("skip@example.com"), "excluded user must be skipped")
+}
+
+func TestRules(t *testing.T) {
+	board := Meeting{ID: 1001, UUID: "abc==", Topic: "Board meeting", HostID: "u1", HostEmail: "Counsel@legal.example.com"}
+	sync := Meeting{ID: 1002, UUID: "def==", Topic: "Weekly sync", HostID: "u2", HostEmail: "me@example.com"}
+
+	for _, tc := range []struct {
+		rule  string
+		board bool
+		sync  bool
+	}{
+		{"topic=Board meeting", true, false},
+		{"topic=Board", false, false},
+		{"topic=re:^Board", true, false},
+		{"topic=glob:Weekly *", false, true},
+		{"id=1002", false, true},
+		{"id=abc==", true, false},
+		{"id=100", false, false},
+		{"host=counsel@legal.example.com", true, false},
+		{"host=glob:*@example.com", false, true},
+		{"user=u2", false, true},
+		{"topic=re:^Board && host=glob:*@legal.example.com", true, false},
+		{"topic=re:^Board && user=u2", false, false},
+		{"topic=re:^Board || user=u2", true, true},
+		{"user=u3 && topic=Weekly sync || id=1001", true, false},
+	} {
+		t.Run(tc.rule, func(t *testing.T) {
+			rule, err := ParseRule(tc.rule, meetingFields)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if rule.Match(board) != tc.board || rule.Match(sync) != tc.sync {
+				t.Errorf("expected board %t and sync %t", tc.board, tc.sync)
+			}
+		})
+	}
+
+	for _, rule := range []string{"topic", "title=Board", "topic=", "topic=re:(unclosed", "topic=Board &&"} {
+		_, err := ParseRule(rule, meetingFields)
+		assert(t, err != nil, "invalid rule must return an error:", rule)
+	}
+
+	filters, err := (&Config{
+		IgnoreTitles: []string{"ignore"},
+		IncludeRules: []string{"host=glob:*@legal.example.com", "topic=glob:Weekly *"},
+		ExcludeRules: []string{"topic=Weekly sync && user=u2"},
+	}).Filters()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	included, reason := filters.IncludedMeeting(board)
+	assert(t, included && reason == "include rule 1 'host=glob:*@legal.example.com' matches", "include rule must include the meeting:", reason)
+
+	included, reason = filters.IncludedMeeting(sync)
+	assert(t, !included && reason == "exclude rule 1 'topic=Weekly sync && user=u2' matches", "exclude rule must take precedence:", reason)
+
+	included, reason = filters.IncludedMeeting(Meeting{Topic: "ignore", HostEmail: "counsel@legal.example.com"})
+	assert(t, !included && reason == "the title is ignored", "ignored title must be skipped:", reason)
+
+	included, _ = filters.IncludedMeeting(Meeting{Topic: "Standup"})
+	assert(t, !included, "meeting must be skipped when no include rule matches")
+}
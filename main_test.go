@@ -1,6 +1,7 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -10,7 +11,11 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -19,12 +24,17 @@ import (
 func SetupTest(t *testing.T, dir string) *ZoomClient {
 	t.Helper()
 
-	mock := NewZoomMockAPI()
-	server := httptest.NewServer(mock)
-	endpointURL, _ := url.Parse(server.URL) //nolint: errcheck
+	cli, _ := SetupTestWithMock(t, dir)
 
-	mock.baseURL = endpointURL
-	mock.Seed()
+	return cli
+}
+
+// SetupTestWithMock sets up the tests and returns the mock api as well
+func SetupTestWithMock(t *testing.T, dir string) (*ZoomClient, *ZoomMockAPI) {
+	t.Helper()
+
+	mock := SetupMockAPI(t)
+	endpointURL := mock.baseURL
 
 	fs, err := newOsFS(dir)
 	if err != nil {
@@ -45,19 +55,61 @@ func SetupTest(t *testing.T, dir string) *ZoomClient {
 		_ = os.RemoveAll(dir)
 	})
 
-	return cli
+	return cli, mock
+}
+
+// SetupMockAPI starts a seeded mock api server
+func SetupMockAPI(t *testing.T) *ZoomMockAPI {
+	t.Helper()
+
+	mock := NewZoomMockAPI()
+	server := httptest.NewServer(mock)
+	endpointURL, _ := url.Parse(server.URL) //nolint: errcheck
+
+	mock.baseURL = endpointURL
+	mock.Seed()
+
+	t.Cleanup(server.Close)
+
+	return mock
 }
 
 // ZoomMockAPI mocks the zoom api for testing
 type ZoomMockAPI struct {
-	baseURL  *url.URL
-	meetings []Meeting
+	baseURL     *url.URL
+	users       []User
+	meetings    []Meeting
+	trash       []Meeting
+	brokenFiles map[string]bool
+
+	// interruptedFiles are the files whose download breaks off after the
+	// amount of bytes once, ranges are the requested ranges
+	interruptedFiles map[string]int
+	ranges           []string
+
+	// contents and etags replace the content and etag of the files
+	contents map[string]string
+	etags    map[string]string
+
+	// rejectHead answers the head requests of the files with a 405
+	rejectHead bool
+
+	// rateLimited is the amount of api calls that are answered with a 429
+	rateLimited     atomic.Int32
+	rateLimitHeader http.Header
+	requests        atomic.Int32
 }
 
 // NewZoomMockAPI returns a new mock api
 func NewZoomMockAPI() *ZoomMockAPI {
 	z := &ZoomMockAPI{}
+	z.users = []User{}
 	z.meetings = []Meeting{}
+	z.trash = []Meeting{}
+	z.brokenFiles = map[string]bool{}
+	z.interruptedFiles = map[string]int{}
+	z.contents = map[string]string{}
+	z.etags = map[string]string{}
 
 	return z
 }
@@ -72,6 +124,13 @@ func (z *ZoomMockAPI) Seed() {
 		"Dancing in the moonlight",
 	}
 
+	z.users = []User{
+		{ID: "user1", Email: "owner@example.com", Status: "active"},
+		{ID: "user2", Email: "colleague@example.com", Status: "active"},
+		{ID: "user3", Email: "intern@example.com", Status: "active"},
+		{ID: "user4", Email: "former@example.com", Status: "inactive"},
+	}
+
 	z.meetings = []Meeting{
 		createMeeting(z.baseURL, `static`, 1001, time.Date(2022, time.October, 1, 0, 0, 0, 0, time.UTC), RecordingTypeAudioOnly, RecordingTypeActiveSpeaker, RecordingTypeGallery, RecordingTypeScharedScreenWithSpeakerCC),
 		createMeeting(z.baseURL, `static`, 1002, time.Date(2022, time.November, 1, 0, 0, 0, 0, time.UTC), RecordingTypeGallery, RecordingTypeActiveSpeaker),
@@ -87,43 +146,145 @@ func (z *ZoomMockAPI) Seed() {
 		z.meetings = append(z.meetings, createRandomMeeting(z.baseURL, topic, i, from))
 	}
 
+	for i := range z.meetings {
+		z.meetings[i].HostID = z.users[0].ID
+		z.meetings[i].HostEmail = z.users[0].Email
+	}
+
+	for i, user := range z.users[1:] {
+		m := createMeeting(z.baseURL, `colleague`, 2001+i, time.Date(2022, time.October, 2, 0, 0, 0, 0, time.UTC), RecordingTypeGallery)
+		m.HostID = user.ID
+		m.HostEmail = user.Email
+		z.meetings = append(z.meetings, m)
+	}
+
+	// a meeting uuid that needs to be double encoded
+	slashed := createMeeting(z.baseURL, `slashed`, 3001, time.Date(2021, time.March, 3, 0, 0, 0, 0, time.UTC), RecordingTypeGallery)
+	slashed.UUID = "/a//b=="
+	slashed.HostID = z.users[1].ID
+	slashed.HostEmail = z.users[1].Email
+	z.meetings = append(z.meetings, slashed)
+
 	log.Printf("added %d entries", len(z.meetings))
 }
 
 // ServeHTTP is an implementation of http.Handler
 func (z *ZoomMockAPI) ServeHTTP(wr http.ResponseWriter, r *http.Request) {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/users/me/recordings", z.listAllRecordings)
+	mux.HandleFunc("/users", z.listUsers)
+	mux.HandleFunc("/users/{userID}", z.getUser)
+	mux.HandleFunc("/users/{userID}/recordings", z.listAllRecordings)
 	mux.HandleFunc("/oauth/token", z.authorize)
-	mux.HandleFunc("/meetings/1001/recordings", z.deleteMeeting)
+	mux.HandleFunc("GET /meetings/{meetingID}/recordings", z.getMeeting)
+	mux.HandleFunc("DELETE /meetings/{meetingID}/recordings", z.deleteMeeting)
+	mux.HandleFunc("DELETE /meetings/{meetingID}/recordings/{recordingID}", z.deleteRecordingFile)
 
 	if strings.HasPrefix(r.URL.Path, "/files") {
 		z.download(wr, r)
 		return
 	}
 
+	z.requests.Add(1)
+	if r.URL.Path != "/oauth/token" && z.rateLimited.Add(-1) >= 0 {
+		for key, values := range z.rateLimitHeader {
+			wr.Header()[key] = values
+		}
+
+		wr.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+
 	mux.ServeHTTP(wr, r)
 }
 
+// RateLimit answers the next n api calls with a 429 with the given headers
+func (z *ZoomMockAPI) RateLimit(n int, header http.Header) {
+	z.rateLimitHeader = header
+	z.rateLimited.Store(int32(n))
+}
+
 func (z *ZoomMockAPI) listAllRecordings(wr http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		wr.WriteHeader(http.StatusNotFound)
 		return
 	}
 
+	userID := r.PathValue("userID")
+	if userID == "me" {
+		userID = z.users[0].ID
+	}
+
 	queries := r.URL.Query()
 	now := time.Now()
 	from := getDate(queries.Get("from"), now).Unix()
-	to := getDate(queries.Get("to"), now).Unix()
+	to := getDate(queries.Get("to"), now).AddDate(0, 0, 1).Unix()
 
-	res := ListAllRecordsResponse{}
+	meetings := []Meeting{}
 	for _, meet := range z.meetings {
 		start := meet.StartTime.Unix()
-		if start > from && to < start {
-			res.Meetings = append(res.Meetings, meet)
+		if meet.HostID == userID && from <= start && start < to {
+			meetings = append(meetings, meet)
 		}
 	}
 
+	res := ListAllRecordsResponse{}
+	res.Meetings, res.NextPageToken = paginate(meetings, queries)
+
+	if err := json.NewEncoder(wr).Encode(res); err != nil {
+		wr.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// paginate returns the page of the items requested with the page_size and
+// next_page_token queries and the token of the next page
+func paginate[T any](items []T, queries url.Values) ([]T, string) {
+	pageSize, err := strconv.Atoi(queries.Get("page_size"))
+	if err != nil || pageSize < 1 {
+		pageSize = 30
+	}
+
+	pageSize = min(pageSize, 300)
+
+	offset, _ := strconv.Atoi(queries.Get("next_page_token")) //nolint: errcheck
+	if offset >= len(items) {
+		return []T{}, ""
+	}
+
+	end := min(offset+pageSize, len(items))
+	if end == len(items) {
+		return items[offset:end], ""
+	}
+
+	return items[offset:end], strconv.Itoa(end)
+}
+
+func (z *ZoomMockAPI) listUsers(wr http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		wr.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// serve a single user per page to exercise the pagination
+	page := 0
+	if token := r.URL.Query().Get("next_page_token"); token != "" {
+		page, _ = strconv.Atoi(token) //nolint: errcheck
+	}
+
+	status := cmp.Or(r.URL.Query().Get("status"), "active")
+	users := slices.DeleteFunc(slices.Clone(z.users), func(u User) bool {
+		return u.Status != status
+	})
+
+	res := ListUsersResponse{}
+	if page < len(users) {
+		res.Users = users[page : page+1]
+	}
+
+	if page+1 < len(users) {
+		res.NextPageToken = strconv.Itoa(page + 1)
+	}
+
 	if err := json.NewEncoder(wr).Encode(res); err != nil {
 		wr.WriteHeader(http.StatusInternalServerError)
 		return
@@ -157,25 +318,105 @@ func (z *ZoomMockAPI) authorize(wr http.ResponseWriter, r *http.Request) {
 }
 
 func (z *ZoomMockAPI) download(wr http.ResponseWriter, r *http.Request) {
-	wr.Header().Add("Content-Length", "16")
+	if z.brokenFiles[path.Base(r.URL.Path)] {
+		wr.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if z.rejectHead && r.Method == http.MethodHead {
+		wr.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := path.Base(r.URL.Path)
+	content := cmp.Or(z.contents[name], "some random file")
+	etag := cmp.Or(z.etags[name], `"v1"`)
+	status := http.StatusOK
+	wr.Header().Set("ETag", etag)
+
+	// the range is ignored when the file changed since the etag
+	if rng := r.Header.Get("Range"); rng != "" && cmp.Or(r.Header.Get("If-Range"), etag) == etag {
+		z.ranges = append(z.ranges, rng)
+
+		var start int
+		if _, err := fmt.Sscanf(rng, "bytes=%d-", &start); err == nil && start < len(content) {
+			wr.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(content)-1, len(content)))
+			content, status = content[start:], http.StatusPartialContent
+		}
+	}
+
+	wr.Header().Set("Content-Length", strconv.Itoa(len(content)))
 
 	if r.Method == http.MethodHead {
-		wr.WriteHeader(http.StatusOK)
+		wr.WriteHeader(status)
 		return
 	}
 
-	fmt.Fprint(wr, "some random file")
+	wr.WriteHeader(status)
+
+	if n, ok := z.interruptedFiles[name]; ok {
+		delete(z.interruptedFiles, name)
+		fmt.Fprint(wr, content[:n])
+		wr.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+
+	fmt.Fprint(wr, content)
+}
+
+func (z *ZoomMockAPI) getUser(wr http.ResponseWriter, r *http.Request) {
+	for _, user := range z.users {
+		if user.ID == r.PathValue("userID") {
+			if err := json.NewEncoder(wr).Encode(user); err != nil {
+				wr.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+
+	wr.WriteHeader(http.StatusNotFound)
+}
+
+// meetingID returns the meeting id of the path, the uuids can be double encoded
+func meetingID(r *http.Request) string {
+	id, err := url.PathUnescape(r.PathValue("meetingID"))
+	if err != nil {
+		return r.PathValue("meetingID")
+	}
+
+	return id
+}
+
+func (z *ZoomMockAPI) getMeeting(wr http.ResponseWriter, r *http.Request) {
+	id := meetingID(r)
+	for _, meet := range z.meetings {
+		if meet.UUID == id || strconv.Itoa(meet.ID) == id {
+			if err := json.NewEncoder(wr).Encode(meet); err != nil {
+				wr.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+
+	wr.WriteHeader(http.StatusNotFound)
 }
 
 func (z *ZoomMockAPI) deleteMeeting(wr http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodDelete {
-		wr.WriteHeader(http.StatusNotFound)
+	id := meetingID(r)
+
+	action := r.URL.Query().Get("action")
+	if action != "trash" && action != "delete" {
+		wr.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	found := false
 	for i := len(z.meetings) - 1; i > -1; i-- {
-		if z.meetings[i].UUID == "1001" {
+		if z.meetings[i].UUID == id {
+			if action == "trash" {
+				z.trash = append(z.trash, z.meetings[i])
+			}
+
 			z.meetings = append(z.meetings[:i], z.meetings[i+1:]...)
 			found = true
 		}
@@ -186,7 +427,42 @@ func (z *ZoomMockAPI) deleteMeeting(wr http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	wr.WriteHeader(http.StatusOK)
+	wr.WriteHeader(http.StatusNoContent)
+}
+
+func (z *ZoomMockAPI) deleteRecordingFile(wr http.ResponseWriter, r *http.Request) {
+	id := meetingID(r)
+	fileID := r.PathValue("recordingID")
+
+	action := r.URL.Query().Get("action")
+	if action != "trash" && action != "delete" {
+		wr.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	for i, meet := range z.meetings {
+		if meet.UUID != id {
+			continue
+		}
+
+		for j, rf := range meet.RecordingFiles {
+			if rf.ID != fileID {
+				continue
+			}
+
+			if action == "trash" {
+				trashed := meet
+				trashed.RecordingFiles = []RecordingFile{rf}
+				z.trash = append(z.trash, trashed)
+			}
+
+			z.meetings[i].RecordingFiles = slices.Delete(slices.Clone(meet.RecordingFiles), j, j+1)
+			wr.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	wr.WriteHeader(http.StatusNotFound)
 }
 
 func createRandomMeeting(baseURL *url.URL, topic string, id int, from time.Time) Meeting {
@@ -215,6 +491,7 @@ func createMeeting(baseURL *url.URL, topic string, id int, startTime time.Time,
 			DownloadURL:    baseURL.JoinPath("files", id).String(),
 			RecordingType:  typ,
 			FileExtension:  getFileExtention(typ),
+			FileSize:       16,
 		})
 	}
 
@@ -269,6 +546,8 @@ func randomRecordingType() RecordingType {
 }
 
 func assert(t *testing.T, condition bool, explaination ...string) {
+	t.Helper()
+
 	if !condition {
 		explaination = append([]string{"assertion failed:"}, explaination...)
 		t.Error(strings.Join(explaination, " "))
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultPathTemplate is the path of the recording files in the destinations
+const DefaultPathTemplate = "{{.User}}/{{.Topic}}/{{.Start}}_{{.Type}}.{{.Ext}}"
+
+// DefaultTimeLayout is the layout of the start time in the paths
+const DefaultTimeLayout = "2006-01-02_15-04-05"
+
+// PathFields are the fields of a recording file that can be used in the path
+// template, the text fields are stripped of the characters that aren't
+// allowed in paths
+type PathFields struct {
+	User      string    // email of the user when the recordings of all users are swept
+	Topic     string    // topic of the meeting
+	MeetingID int       // id of the meeting
+	UUID      string    // uuid of the meeting with the slashes replaced by _
+	HostEmail string    // email of the host
+	HostID    string    // user id of the host
+	Type      string    // recording type
+	FileID    string    // id of the recording file
+	Ext       string    // lower case file extension
+	Time      time.Time // start of the recording in the timezone, for custom layouts
+	Start     string    // start of the recording with the time layout
+	Year      string    // year of the start
+	Month     string    // month of the start (01-12)
+	Day       string    // day of the start (01-31)
+}
+
+// PathTemplate renders the paths of the recording files
+type PathTemplate struct {
+	tmpl     *template.Template
+	layout   string
+	location *time.Location
+}
+
+// NewPathTemplate parses the path template, the start time is formatted with
+// the layout in the timezone
+func NewPathTemplate(text, layout, timezone string) (*PathTemplate, error) {
+	tmpl, err := template.New("path").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path template: %w", err)
+	}
+
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone '%s': %w", timezone, err)
+	}
+
+	return &PathTemplate{tmpl: tmpl, layout: layout, location: location}, nil
+}
+
+// Path returns the path of the recording file of the meeting, the path is
+// cleaned so it can't leave the destination
+func (p *PathTemplate) Path(user User, meeting Meeting, rf RecordingFile) (string, error) {
+	start := rf.RecordingStart.In(p.location)
+
+	fields := PathFields{
+		User:      serializPathString(user.Email),
+		Topic:     serializPathString(meeting.Topic),
+		MeetingID: meeting.ID,
+		UUID:      serializPathString(strings.ReplaceAll(meeting.UUID, "/", "_")),
+		HostEmail: serializPathString(meeting.HostEmail),
+		HostID:    meeting.HostID,
+		Type:      string(rf.RecordingType),
+		FileID:    rf.ID,
+		Ext:       strings.ToLower(rf.FileExtension),
+		Time:      start,
+		Start:     start.Format(p.layout),
+		Year:      start.Format("2006"),
+		Month:     start.Format("01"),
+		Day:       start.Format("02"),
+	}
+
+	var out strings.Builder
+	if err := p.tmpl.Execute(&out, fields); err != nil {
+		return "", fmt.Errorf("unable to render path of %s: %w", rf.ID, err)
+	}
+
+	target := strings.TrimPrefix(path.Clean("/"+out.String()), "/")
+	if target == "" {
+		return "", fmt.Errorf("path of %s is empty", rf.ID)
+	}
+
+	return target, nil
+}
+
+// validate checks if the template gives different paths to the recording
+// files that can exist next to each other: the files of a meeting, the
+// files of a restarted recording and (with all users) the files of meetings
+// of different users with the same topic at the same time
+func (p *PathTemplate) validate(allUsers bool) error {
+	// the user is only set when the recordings of all users are swept
+	user := User{}
+	if allUsers {
+		user = User{ID: "user1", Email: "owner@example.com"}
+	}
+
+	meeting := Meeting{ID: 1001, UUID: "uuid1==", Topic: "Weekly sync", HostID: "user1", HostEmail: "owner@example.com"}
+	rf := RecordingFile{
+		ID:             "file1",
+		RecordingType:  RecordingTypeGallery,
+		RecordingStart: time.Date(2024, time.January, 1, 9, 0, 0, 0, time.UTC),
+		FileExtension:  "MP4",
+	}
+
+	speaker := rf
+	speaker.ID, speaker.RecordingType = "file2", RecordingTypeActiveSpeaker
+
+	restarted := rf
+	restarted.ID, restarted.RecordingStart = "file3", rf.RecordingStart.Add(time.Minute)
+
+	type file struct {
+		user    User
+		meeting Meeting
+		rf      RecordingFile
+		desc    string
+	}
+
+	files := []file{
+		{user, meeting, rf, "a recording file"},
+		{user, meeting, speaker, "a recording file of another type"},
+		{user, meeting, restarted, "a restarted recording"},
+	}
+
+	if allUsers {
+		colleague := User{ID: "user2", Email: "colleague@example.com"}
+		other := Meeting{ID: 1002, UUID: "uuid2==", Topic: meeting.Topic, HostID: colleague.ID, HostEmail: colleague.Email}
+		otherFile := rf
+		otherFile.ID = "file4"
+
+		files = append(files, file{colleague, other, otherFile, "a meeting of another user"})
+	}
+
+	paths := map[string]string{}
+
+	var errs error
+	for _, f := range files {
+		target, err := p.Path(f.user, f.meeting, f.rf)
+		if err != nil {
+			return err
+		}
+
+		if prev, exists := paths[target]; exists {
+			errs = errors.Join(errs, fmt.Errorf("path template gives %s and %s the same path '%s'", prev, f.desc, target))
+			continue
+		}
+
+		paths[target] = f.desc
+	}
+
+	return errs
+}
+
+// Paths parses the path template of the config and checks if it gives
+// every recording file its own path
+func (c *Config) Paths() (*PathTemplate, error) {
+	paths, err := NewPathTemplate(
+		cmp.Or(c.PathTemplate, DefaultPathTemplate),
+		cmp.Or(c.TimeLayout, DefaultTimeLayout),
+		cmp.Or(c.Timezone, "UTC"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := paths.validate(c.AllUsers); err != nil {
+		return nil, err
+	}
+
+	return paths, nil
+}
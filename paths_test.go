@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPathTemplate(t *testing.T) {
+	user := User{Email: "owner@example.com"}
+	meeting := Meeting{ID: 1001, UUID: "/a//b==", Topic: "Board: Q1 'review'", HostID: "user1", HostEmail: "owner@example.com"}
+	rf := RecordingFile{
+		ID:             "file1",
+		RecordingType:  RecordingTypeGallery,
+		RecordingStart: time.Date(2024, time.January, 31, 23, 30, 0, 0, time.UTC),
+		FileExtension:  "MP4",
+	}
+
+	for _, tc := range []struct {
+		template string
+		layout   string
+		timezone string
+		expected string
+	}{
+		{DefaultPathTemplate, "", "", "owner@example.com/Board - Q1 review/2024-01-31_23-30-00_gallery_view.mp4"},
+		{"{{.Year}}/{{.Month}}/{{.Topic}}/{{.Start}}_{{.Type}}.{{.Ext}}", "", "", "2024/01/Board - Q1 review/2024-01-31_23-30-00_gallery_view.mp4"},
+		{"{{.Start}}/{{.FileID}}.{{.Ext}}", "20060102T1504", "Europe/Amsterdam", "20240201T0030/file1.mp4"},
+		{"{{.Year}}/{{.Month}}/{{.Day}}/{{.MeetingID}}_{{.FileID}}", "", "Asia/Tokyo", "2024/02/01/1001_file1"},
+		{`{{.Time.Format "Jan 2006"}}/{{.UUID}}/{{.FileID}}`, "", "", "Jan 2024/_a__b==/file1"},
+		{"{{.HostID}}/{{.HostEmail}}/{{.FileID}}", "", "", "user1/owner@example.com/file1"},
+		{"../../{{.FileID}}", "", "", "file1"},
+		{"/{{.User}}//{{.FileID}}", "", "", "owner@example.com/file1"},
+	} {
+		t.Run(tc.template, func(t *testing.T) {
+			paths, err := (&Config{PathTemplate: tc.template, TimeLayout: tc.layout, Timezone: tc.timezone}).Paths()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			target, err := paths.Path(user, meeting, rf)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if target != tc.expected {
+				t.Errorf("expected path '%s' but got '%s'", tc.expected, target)
+			}
+		})
+	}
+}
+
+func TestPathTemplateErrors(t *testing.T) {
+	for _, tc := range []struct {
+		config   Config
+		expected string
+	}{
+		{Config{PathTemplate: "{{.Topic}}.{{.Ext}}"}, "a recording file and a recording file of another type the same path"},
+		{Config{PathTemplate: "{{.Topic}}/{{.Start}}_{{.Type}}.{{.Ext}}", TimeLayout: time.DateOnly}, "a recording file and a restarted recording the same path"},
+		{Config{PathTemplate: "{{.Topic}}/{{.Start}}_{{.Type}}.{{.Ext}}", AllUsers: true}, "a recording file and a meeting of another user the same path"},
+		{Config{PathTemplate: "{{.Topic"}, "invalid path template"},
+		{Config{PathTemplate: "{{.Title}}/{{.FileID}}"}, "can't evaluate field Title"},
+		{Config{PathTemplate: "{{if false}}x{{end}}"}, "is empty"},
+		{Config{Timezone: "Mars/Olympus_Mons"}, "invalid timezone"},
+	} {
+		_, err := tc.config.Paths()
+		assert(t, err != nil && strings.Contains(err.Error(), tc.expected), "expected error", tc.expected, "for", tc.config.PathTemplate)
+	}
+
+	_, err := (&Config{PathTemplate: "{{.Topic}}/{{.Start}}_{{.Type}}.{{.Ext}}"}).Paths()
+	assert(t, err == nil, "path without the user must be unique when only one user is swept")
+}
+
+func TestSweepPathTemplate(t *testing.T) {
+	dir := "tmp_test_sweep_path_template"
+	c := SetupTest(t, dir)
+
+	c.config.RecordingTypes = []string{string(RecordingTypeGallery)}
+	c.config.StartingFromYear = 2022
+
+	filters, _ := c.config.Filters() //nolint: errcheck
+	paths, _ := c.config.Paths()     //nolint: errcheck
+	state := newJSONStore(context.Background(), c, &RecordHolder{})
+
+	// meetings of the same topic at the same time get the same path
+	plan := &SweepPlan{}
+	for id := range 2 {
+		meeting := createMeeting(c.config.APIEndpoint, "standup", id, time.Date(2024, time.January, 1, 9, 0, 0, 0, time.UTC), RecordingTypeGallery)
+		if err := c.planDownloads(plan, User{}, meeting, state, filters, paths); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	assert(t, len(plan.Downloads) == 1, "recording file with the path of another file must not be downloaded")
+
+	c.config.PathTemplate = "{{.Year}}/{{.Month}}/{{.Topic}}/{{.Start}}_{{.Type}}.{{.Ext}}"
+	c.config.TimeLayout = "02_15h04"
+
+	if err := c.Sweep(); err != nil {
+		t.Fatalf("unexpected error during sweep: %v", err)
+	}
+
+	assertFileExists(t, path.Join(dir, "2022/10/static/01_00h00_gallery_view.mp4"))
+	assertFileExists(t, path.Join(dir, "2023/01/static2/01_00h00_gallery_view.mp4"))
+	assertFileNotExists(t, path.Join(dir, "static"))
+}
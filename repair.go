@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// RepairReport contains the files that are copied to the destinations that
+// were missing them
+type RepairReport struct {
+	Account  string         `json:"account,omitempty"`
+	Repaired []RepairedFile `json:"repaired"`
+	Failed   []FailedRepair `json:"failed"`
+}
+
+// RepairedFile is a file that is copied from a healthy destination
+type RepairedFile struct {
+	Path string `json:"path"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// FailedRepair is a file that couldn't be repaired in the destination
+type FailedRepair struct {
+	Path        string `json:"path"`
+	Destination string `json:"destination"`
+	Reason      string `json:"reason"`
+}
+
+// Repair copies the files of the saved records that are missing (or have a
+// different size) in a destination from a destination that has them, the
+// copy is checked against the saved hash, with dryRun nothing is copied.
+// The destination states of the records are updated with the results
+func (z *ZoomClient) Repair(dryRun bool) (report *RepairReport, err error) {
+	ctx, done := z.begin()
+	defer done()
+
+	state, err := z.openState(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer z.closeState(state, &err)
+
+	records, err := state.Records(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report = &RepairReport{
+		Account:  z.config.Name,
+		Repaired: []RepairedFile{},
+		Failed:   []FailedRepair{},
+	}
+
+	var errs error
+	for _, rec := range records {
+		changed := false
+
+		healthy, lagging := []destination{}, []destination{}
+		for _, dst := range z.recordDestinations(rec) {
+			info, err := dst.Stat(ctx, rec.Path)
+			switch {
+			case errors.Is(err, fs.ErrNotExist), err == nil && rec.Size > 0 && info.Size != rec.Size:
+				lagging = append(lagging, dst)
+			case err != nil:
+				errs = errors.Join(errs, fmt.Errorf("%s: %w", dst.name, err))
+			default:
+				healthy = append(healthy, dst)
+
+				// the file is there but wasn't written by a sweep or repair
+				if state, ok := rec.state(dst.name); !dryRun && (!ok || !state.OK) {
+					rec.setState(DestinationState{Destination: dst.name, OK: true, WrittenAt: info.ModTime, Size: info.Size})
+					changed = true
+				}
+			}
+		}
+
+		for _, dst := range lagging {
+			if len(healthy) == 0 {
+				report.Failed = append(report.Failed, FailedRepair{Path: rec.Path, Destination: dst.name, Reason: "no destination has the file"})
+				if !dryRun {
+					rec.setState(DestinationState{Destination: dst.name, Error: "no destination has the file"})
+					changed = true
+				}
+
+				continue
+			}
+
+			if dryRun {
+				report.Repaired = append(report.Repaired, RepairedFile{Path: rec.Path, From: healthy[0].name, To: dst.name})
+				continue
+			}
+
+			from, copied, err := z.copyFile(rec, healthy, dst)
+			if err != nil {
+				z.logger.Printf("unable to repair %s in %s: %v", rec.Path, dst.name, err)
+				report.Failed = append(report.Failed, FailedRepair{Path: rec.Path, Destination: dst.name, Reason: err.Error()})
+				copied = DestinationState{Destination: dst.name, Error: err.Error()}
+			} else {
+				z.logger.Printf("repaired %s in %s from %s", rec.Path, dst.name, from)
+				report.Repaired = append(report.Repaired, RepairedFile{Path: rec.Path, From: from, To: dst.name})
+			}
+
+			rec.setState(copied)
+			changed = true
+		}
+
+		if changed {
+			errs = errors.Join(errs, state.SaveRecord(ctx, rec))
+		}
+	}
+
+	return report, errs
+}
+
+// copyFile copies the file of the record to the destination from the first
+// source with a matching copy and returns the name of that source
+func (z *ZoomClient) copyFile(rec SavedRecord, sources []destination, dst destination) (string, DestinationState, error) {
+	var errs error
+	for _, src := range sources {
+		state, err := z.copyFrom(rec, src, dst)
+		if err == nil {
+			return src.name, state, nil
+		}
+
+		errs = errors.Join(errs, fmt.Errorf("from %s: %w", src.name, err))
+	}
+
+	return "", DestinationState{}, errs
+}
+
+// copyFrom copies the file of the record and returns the state of the copy
+func (z *ZoomClient) copyFrom(rec SavedRecord, src, dst destination) (DestinationState, error) {
+	rd, err := src.Reader(z.context, rec.Path)
+	if err != nil {
+		return DestinationState{}, err
+	}
+
+	if closer, ok := rd.(io.Closer); ok {
+		defer closer.Close() //nolint: errcheck
+	}
+
+	file, err := dst.Writer(z.context, rec.Path)
+	if err != nil {
+		return DestinationState{}, err
+	}
+
+	hw := newHashWriter(file)
+	if _, err := io.Copy(hw, rd); err != nil {
+		return DestinationState{}, errors.Join(err, file.Abort())
+	}
+
+	if rec.Size > 0 && hw.size != rec.Size {
+		return DestinationState{}, errors.Join(fmt.Errorf("size %d but %d bytes were downloaded", hw.size, rec.Size), file.Abort())
+	}
+
+	if rec.SHA256 != "" && !strings.EqualFold(hw.Sum(), rec.SHA256) {
+		return DestinationState{}, errors.Join(fmt.Errorf("sha256 %s but %s was downloaded", hw.Sum(), rec.SHA256), file.Abort())
+	}
+
+	if err := file.Close(); err != nil {
+		return DestinationState{}, err
+	}
+
+	return DestinationState{
+		Destination: dst.name,
+		OK:          true,
+		WrittenAt:   time.Now(),
+		Size:        hw.size,
+		SHA256:      hw.Sum(),
+	}, nil
+}
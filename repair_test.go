@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestRepair(t *testing.T) {
+	dir := "tmp_test_repair"
+	c := SetupTest(t, dir)
+
+	first, _ := newOsFS(path.Join(dir, "first"))   //nolint: errcheck
+	second, _ := newOsFS(path.Join(dir, "second")) //nolint: errcheck
+	c.fs = multifs{dsts: []destination{{FileSystem: first, name: "first"}, {FileSystem: second, name: "second"}}}
+
+	content := []byte("some random file")
+	_ = os.MkdirAll(path.Join(dir, "first", "static"), os.ModePerm)                  //nolint: errcheck
+	_ = os.WriteFile(path.Join(dir, "first", "static", "good.mp4"), content, 0o644)  //nolint: errcheck
+	_ = os.WriteFile(path.Join(dir, "first", "static", "wrong.mp4"), content, 0o644) //nolint: errcheck
+
+	if err := c.saveRecords(context.Background(), &RecordHolder{Records: []SavedRecord{
+		{ID: "good", Path: "static/good.mp4", Size: 16, SHA256: fmt.Sprintf("%x", sha256.Sum256(content))},
+		{ID: "wrong", Path: "static/wrong.mp4", Size: 16, SHA256: fmt.Sprintf("%x", sha256.Sum256([]byte("another file")))},
+	}}); err != nil {
+		t.Fatalf("unable to save records: %v", err)
+	}
+
+	report, err := c.Repair(true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assert(t, len(report.Repaired) == 2, "dry run must show the files that would be copied")
+	assertFileNotExists(t, path.Join(dir, "second", "static", "good.mp4"))
+
+	report, err = c.Repair(false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assert(t, len(report.Repaired) == 1 && report.Repaired[0].Path == "static/good.mp4", "missing file must be copied")
+	assert(t, len(report.Failed) == 1 && report.Failed[0].Path == "static/wrong.mp4", "file with another hash must not be copied")
+
+	copied, err := os.ReadFile(path.Join(dir, "second", "static", "good.mp4"))
+	assert(t, err == nil && string(copied) == string(content), "copied file must be complete")
+	assertFileNotExists(t, path.Join(dir, "second", "static", "wrong.mp4"))
+}
+
+func TestRepairFailedWrites(t *testing.T) {
+	dir := "tmp_test_repair_failed_writes"
+	c := SetupTest(t, dir)
+
+	c.config.RecordingTypes = []string{string(RecordingTypeGallery)}
+	c.config.StartingFromYear = 2022
+
+	first, _ := newOsFS(path.Join(dir, "first"))   //nolint: errcheck
+	second, _ := newOsFS(path.Join(dir, "second")) //nolint: errcheck
+	third, _ := newOsFS(path.Join(dir, "third"))   //nolint: errcheck
+	c.fs = multifs{policy: "quorum", dsts: []destination{
+		{FileSystem: first, name: "first"},
+		{FileSystem: second, name: "second"},
+		{FileSystem: brokenfs{third}, name: "third"},
+	}}
+
+	// the working destinations have the records file of the archive
+	if err := c.saveRecords(context.Background(), &RecordHolder{}); err != nil {
+		t.Fatalf("unable to save records: %v", err)
+	}
+
+	if err := c.Sweep(); err != nil {
+		t.Fatalf("a failing destination must not stop the sweep: %v", err)
+	}
+
+	records, err := c.LoadRecords()
+	if err != nil {
+		t.Fatalf("unable to load records: %v", err)
+	}
+
+	assert(t, len(records.Records) > 0, "files must be saved to the working destinations")
+	for _, rec := range records.Records {
+		state, ok := rec.state("third")
+		assert(t, ok && !state.OK && state.Error != "", "failed destination must be queued for repair")
+
+		state, ok = rec.state("first")
+		assert(t, ok && state.OK && state.Size == 16 && state.SHA256 == rec.SHA256, "written destination must have the state of the file")
+		assertFileNotExists(t, path.Join(dir, "third", rec.Path))
+	}
+
+	c.fs.(multifs).dsts[2].FileSystem = third
+
+	report, err := c.Repair(false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assert(t, len(report.Repaired) == len(records.Records), "failed destination must be repaired")
+
+	records, _ = c.LoadRecords() //nolint: errcheck
+	for _, rec := range records.Records {
+		state, ok := rec.state("third")
+		assert(t, ok && state.OK && state.SHA256 == rec.SHA256, "repaired destination must have the state of the copy")
+		assertFileExists(t, path.Join(dir, "third", rec.Path))
+	}
+}
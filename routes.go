@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Route sends the recording files matching the rule to its own destinations
+// instead of the default destinations, e.g.
+// "topic=re:^Board -> s3://host/legal-archive" or
+// "type=audio_transcript -> file:///transcripts"
+type Route struct {
+	Source       string
+	Rule         *Rule
+	Destinations []string
+}
+
+// ParseRoute parses the route, the rule and the comma separated
+// destinations are separated by ->
+func ParseRoute(route string) (*Route, error) {
+	rule, dsts, ok := strings.Cut(route, "->")
+	if !ok {
+		return nil, fmt.Errorf("invalid route '%s': must be <rule> -> <destinations>", redactRoute(route))
+	}
+
+	r := &Route{Source: redactRoute(route)}
+
+	var err error
+	if r.Rule, err = ParseRule(strings.TrimSpace(rule), fileFields); err != nil {
+		return nil, fmt.Errorf("invalid route '%s': %w", r.Source, err)
+	}
+
+	for dst := range strings.SplitSeq(dsts, ",") {
+		if dst = strings.TrimSpace(dst); dst != "" {
+			r.Destinations = append(r.Destinations, dst)
+		}
+	}
+
+	if len(r.Destinations) == 0 {
+		return nil, fmt.Errorf("invalid route '%s': no destinations", r.Source)
+	}
+
+	return r, nil
+}
+
+// redactRoute removes the credentials of the destinations from the route
+// so it can be logged and shown in the plan
+func redactRoute(route string) string {
+	rule, dsts, ok := strings.Cut(route, "->")
+	if !ok {
+		return route
+	}
+
+	redacted := []string{}
+	for dst := range strings.SplitSeq(dsts, ",") {
+		redacted = append(redacted, redactURL(strings.TrimSpace(dst)))
+	}
+
+	return strings.TrimSpace(rule) + " -> " + strings.Join(redacted, ", ")
+}
+
+// ParseRoutes parses the routes of the config
+func (c *Config) ParseRoutes() ([]*Route, error) {
+	routes := make([]*Route, 0, len(c.Routes))
+	for _, route := range c.Routes {
+		r, err := ParseRoute(route)
+		if err != nil {
+			return nil, fmt.Errorf("routes: %w", err)
+		}
+
+		routes = append(routes, r)
+	}
+
+	return routes, nil
+}
+
+// route is a route with the file system of its destinations
+type route struct {
+	*Route
+	fs FileSystem
+}
+
+// openRoutes opens the destinations of every route, the destinations are
+// written with the write policy of the config
+func openRoutes(ctx context.Context, cfg *Config) ([]route, error) {
+	routes, err := cfg.ParseRoutes()
+	if err != nil {
+		return nil, err
+	}
+
+	opened := make([]route, 0, len(routes))
+	for _, r := range routes {
+		fs, err := openDestinations(ctx, cfg, r.Destinations)
+		if err != nil {
+			return nil, fmt.Errorf("route '%s': %w", r.Source, err)
+		}
+
+		opened = append(opened, route{Route: r, fs: fs})
+	}
+
+	return opened, nil
+}
+
+// route returns the file system and the source of the first route that
+// matches the recording file, files without a matching route are written
+// to the default destinations
+func (z *ZoomClient) route(meeting Meeting, rf RecordingFile) (FileSystem, string) {
+	for _, r := range z.routes {
+		if r.Rule.MatchFile(meeting, rf) {
+			return r.fs, r.Source
+		}
+	}
+
+	return z.fs, ""
+}
+
+// allDestinations returns the default destinations and the destinations of
+// the routes, every destination once
+func (z *ZoomClient) allDestinations() []destination {
+	all := slices.Clone(destinations(z.fs))
+	for _, r := range z.routes {
+		for _, dst := range destinations(r.fs) {
+			if !slices.ContainsFunc(all, func(d destination) bool { return d.name == dst.name }) {
+				all = append(all, dst)
+			}
+		}
+	}
+
+	return all
+}
+
+// recordDestinations returns the destinations the file of the record is
+// written to, records without destination states are in the default
+// destinations
+func (z *ZoomClient) recordDestinations(rec SavedRecord) []destination {
+	dsts := []destination{}
+	for _, dst := range z.allDestinations() {
+		if _, ok := rec.state(dst.name); ok {
+			dsts = append(dsts, dst)
+		}
+	}
+
+	if len(dsts) == 0 {
+		return destinations(z.fs)
+	}
+
+	return dsts
+}
+
+// recordFS returns the file system of the destinations of the record
+func (z *ZoomClient) recordFS(rec SavedRecord) FileSystem {
+	if len(z.routes) == 0 {
+		return z.fs
+	}
+
+	return multifs{dsts: z.recordDestinations(rec)}
+}
+
+// redactURL returns the url without the credentials
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "[invalid url]"
+	}
+
+	return u.Redacted()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestRoutes(t *testing.T) {
+	dir := "tmp_test_routes"
+	c := SetupTest(t, dir)
+
+	c.config.RecordingTypes = []string{string(RecordingTypeGallery), string(RecordingTypeActiveSpeaker)}
+	c.config.StartingFromYear = 2022
+
+	base := t.TempDir()
+	c.config.Routes = []string{
+		"type=active_speaker -> file://" + path.Join(base, "speakers"),
+		"topic=static2 -> file://" + path.Join(base, "archive") + ", file://" + path.Join(base, "backup"),
+	}
+
+	routes, err := openRoutes(context.Background(), c.config)
+	if err != nil {
+		t.Fatalf("unable to open routes: %v", err)
+	}
+
+	c.routes = routes
+
+	if err := c.Sweep(); err != nil {
+		t.Fatalf("unexpected error during sweep: %v", err)
+	}
+
+	assertFileExists(t, path.Join(dir, "static/2022-10-01_00-00-00_gallery_view.mp4"))
+	assertFileNotExists(t, path.Join(dir, "static/2022-10-01_00-00-00_active_speaker.mp4"))
+	assertFileExists(t, path.Join(base, "speakers/static/2022-10-01_00-00-00_active_speaker.mp4"))
+	assertFileExists(t, path.Join(base, "speakers/static2/2023-01-01_00-00-00_active_speaker.mp4"))
+	assertFileExists(t, path.Join(base, "archive/static2/2023-01-01_00-00-00_gallery_view.mp4"))
+	assertFileExists(t, path.Join(base, "backup/static2/2023-01-01_00-00-00_gallery_view.mp4"))
+	assertFileNotExists(t, path.Join(dir, "static2/2023-01-01_00-00-00_gallery_view.mp4"))
+
+	records, err := c.LoadRecords()
+	if err != nil {
+		t.Fatalf("unable to load records: %v", err)
+	}
+
+	for _, rec := range records.Records {
+		dsts := c.recordDestinations(rec)
+		switch {
+		case strings.HasSuffix(rec.Path, "active_speaker.mp4"):
+			assert(t, len(dsts) == 1 && strings.HasSuffix(dsts[0].name, "/speakers"), "record must have the destination of its route", rec.Path)
+		case strings.HasPrefix(rec.Path, "static2/"):
+			assert(t, len(dsts) == 2, "record must have the destinations of its route", rec.Path)
+		default:
+			assert(t, len(dsts) == 1 && dsts[0].name == "destination", "record must have the default destination", rec.Path)
+		}
+	}
+
+	report, err := c.Verify(false)
+	assert(t, err == nil && report.OK(), "routed files must be verified in their destinations")
+	assert(t, len(report.Destinations) == 4, "every destination must be verified")
+
+	_ = os.Remove(path.Join(base, "backup/static2/2023-01-01_00-00-00_gallery_view.mp4")) //nolint: errcheck
+
+	repaired, err := c.Repair(false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assert(t, len(repaired.Repaired) == 1 && strings.HasSuffix(repaired.Repaired[0].From, "/archive"), "routed file must be repaired from its route")
+	assertFileExists(t, path.Join(base, "backup/static2/2023-01-01_00-00-00_gallery_view.mp4"))
+}
+
+func TestParseRoute(t *testing.T) {
+	route, err := ParseRoute("type=audio_transcript && topic=re:^Board -> s3://key:secret@host/legal, file:///transcripts")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assert(t, len(route.Destinations) == 2, "route must have every destination")
+	assert(t, !strings.Contains(route.Source, "secret"), "route must not show the credentials", route.Source)
+	assert(t, route.Rule.MatchFile(Meeting{Topic: "Board meeting"}, RecordingFile{RecordingType: RecordingTypeAudioTranscript}), "route must match the recording file")
+	assert(t, !route.Rule.MatchFile(Meeting{Topic: "Board meeting"}, RecordingFile{RecordingType: RecordingTypeGallery}), "route must not match other recording types")
+
+	for _, invalid := range []string{"type=chat_file", "type=chat_file ->", "size=1 -> file:///sizes"} {
+		_, err := ParseRoute(invalid)
+		assert(t, err != nil, "invalid route must return an error:", invalid)
+	}
+
+	_, err = (&Config{IncludeRules: []string{"type=chat_file"}}).Filters()
+	assert(t, err != nil, "include rules must not match recording types")
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// SetupS3Mock starts a mock s3 api with a single bucket
+func SetupS3Mock(t *testing.T) *S3MockAPI {
+	t.Helper()
+
+	mock := &S3MockAPI{
+		bucket:  "zoomdl",
+		objects: map[string]s3Object{},
+		uploads: map[string]map[int32][]byte{},
+	}
+
+	server := httptest.NewServer(mock)
+	t.Cleanup(server.Close)
+
+	mock.client = s3.New(s3.Options{
+		BaseEndpoint:               aws.String(server.URL),
+		Region:                     "us-east-1",
+		UsePathStyle:               true,
+		Credentials:                aws.AnonymousCredentials{},
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+		ResponseChecksumValidation: aws.ResponseChecksumValidationWhenRequired,
+	})
+
+	return mock
+}
+
+// S3MockAPI mocks the s3 api calls of the s3 destinations with path style
+// requests, it keeps the objects and multipart uploads in memory
+type S3MockAPI struct {
+	bucket string
+	client *s3.Client
+
+	mut     sync.Mutex
+	objects map[string]s3Object
+	uploads map[string]map[int32][]byte
+	count   int
+}
+
+type s3Object struct {
+	data    []byte
+	modTime time.Time
+}
+
+// Bucket returns the bucket of the mock, objects are uploaded and downloaded
+// in a single request
+func (m *S3MockAPI) Bucket() s3Bucket {
+	return s3MockBucket{client: m.client, name: m.bucket}
+}
+
+func (m *S3MockAPI) ServeHTTP(wr http.ResponseWriter, r *http.Request) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != m.bucket {
+		s3Error(wr, r, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	query := r.URL.Query()
+	copySource := r.Header.Get("X-Amz-Copy-Source")
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		m.listObjects(wr, query.Get("prefix"))
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		m.createUpload(wr, key)
+	case query.Has("uploadId"):
+		m.upload(wr, r, key, copySource)
+	case r.Method == http.MethodPut && copySource != "":
+		data, ok := m.copySource(copySource, "")
+		if !ok {
+			s3Error(wr, r, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+
+		m.objects[key] = s3Object{data: data, modTime: time.Now()}
+		writeXML(wr, struct {
+			XMLName xml.Name `xml:"CopyObjectResult"`
+			ETag    string
+		}{ETag: etag(data)})
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body) //nolint: errcheck
+		m.objects[key] = s3Object{data: data, modTime: time.Now()}
+		wr.Header().Set("ETag", etag(data))
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		obj, ok := m.objects[key]
+		if !ok {
+			s3Error(wr, r, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+
+		wr.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		wr.Header().Set("ETag", etag(obj.data))
+		wr.Header().Set("Last-Modified", obj.modTime.UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			wr.Write(obj.data) //nolint: errcheck
+		}
+	case r.Method == http.MethodDelete:
+		delete(m.objects, key)
+		wr.WriteHeader(http.StatusNoContent)
+	default:
+		s3Error(wr, r, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (m *S3MockAPI) listObjects(wr http.ResponseWriter, prefix string) {
+	type content struct {
+		Key          string
+		LastModified time.Time
+		ETag         string
+		Size         int
+	}
+
+	res := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		IsTruncated bool
+		Contents    []content
+	}{Name: m.bucket, Prefix: prefix}
+
+	for key, obj := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			res.Contents = append(res.Contents, content{Key: key, LastModified: obj.modTime.UTC(), ETag: etag(obj.data), Size: len(obj.data)})
+		}
+	}
+
+	slices.SortFunc(res.Contents, func(a, b content) int { return strings.Compare(a.Key, b.Key) })
+	res.KeyCount = len(res.Contents)
+
+	writeXML(wr, res)
+}
+
+func (m *S3MockAPI) createUpload(wr http.ResponseWriter, key string) {
+	m.count++
+	uploadID := fmt.Sprintf("upload%d", m.count)
+	m.uploads[uploadID] = map[int32][]byte{}
+
+	writeXML(wr, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Bucket   string
+		Key      string
+		UploadId string
+	}{Bucket: m.bucket, Key: key, UploadId: uploadID})
+}
+
+// upload handles the calls on a multipart upload
+func (m *S3MockAPI) upload(wr http.ResponseWriter, r *http.Request, key, copySource string) {
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+
+	parts, ok := m.uploads[uploadID]
+	if !ok {
+		s3Error(wr, r, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		number, _ := strconv.Atoi(query.Get("partNumber")) //nolint: errcheck
+
+		if copySource == "" {
+			data, _ := io.ReadAll(r.Body) //nolint: errcheck
+			parts[int32(number)] = data
+			wr.Header().Set("ETag", etag(data))
+			return
+		}
+
+		data, ok := m.copySource(copySource, r.Header.Get("X-Amz-Copy-Source-Range"))
+		if !ok {
+			s3Error(wr, r, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+
+		parts[int32(number)] = data
+		writeXML(wr, struct {
+			XMLName xml.Name `xml:"CopyPartResult"`
+			ETag    string
+		}{ETag: etag(data)})
+	case http.MethodGet:
+		type part struct {
+			PartNumber int32
+			ETag       string
+			Size       int
+		}
+
+		res := struct {
+			XMLName     xml.Name `xml:"ListPartsResult"`
+			Bucket      string
+			Key         string
+			UploadId    string
+			IsTruncated bool
+			Part        []part
+		}{Bucket: m.bucket, Key: key, UploadId: uploadID}
+
+		for number, data := range parts {
+			res.Part = append(res.Part, part{PartNumber: number, ETag: etag(data), Size: len(data)})
+		}
+
+		slices.SortFunc(res.Part, func(a, b part) int { return int(a.PartNumber - b.PartNumber) })
+
+		writeXML(wr, res)
+	case http.MethodPost:
+		completed := struct {
+			Part []struct {
+				PartNumber int32
+				ETag       string
+			}
+		}{}
+
+		if err := xml.NewDecoder(r.Body).Decode(&completed); err != nil {
+			s3Error(wr, r, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+
+		data := []byte{}
+		for _, part := range completed.Part {
+			if etag(parts[part.PartNumber]) != part.ETag {
+				s3Error(wr, r, http.StatusBadRequest, "InvalidPart")
+				return
+			}
+
+			data = append(data, parts[part.PartNumber]...)
+		}
+
+		delete(m.uploads, uploadID)
+		m.objects[key] = s3Object{data: data, modTime: time.Now()}
+
+		writeXML(wr, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string
+			Key     string
+			ETag    string
+		}{Bucket: m.bucket, Key: key, ETag: etag(data)})
+	case http.MethodDelete:
+		delete(m.uploads, uploadID)
+		wr.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// copySource returns the data of the copy source in the bucket, limited to
+// the range when it's set
+func (m *S3MockAPI) copySource(source, rng string) ([]byte, bool) {
+	source, err := url.PathUnescape(strings.TrimPrefix(source, "/"))
+	if err != nil {
+		return nil, false
+	}
+
+	bucket, key, _ := strings.Cut(source, "/")
+	obj, ok := m.objects[key]
+	if bucket != m.bucket || !ok {
+		return nil, false
+	}
+
+	if rng == "" {
+		return obj.data, true
+	}
+
+	var start, end int
+	if _, err := fmt.Sscanf(rng, "bytes=%d-%d", &start, &end); err != nil || end >= len(obj.data) {
+		return nil, false
+	}
+
+	return obj.data[start : end+1], true
+}
+
+func etag(data []byte) string {
+	return fmt.Sprintf(`"%x"`, md5.Sum(data))
+}
+
+func writeXML(wr http.ResponseWriter, v any) {
+	wr.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(wr).Encode(v) //nolint: errcheck
+}
+
+func s3Error(wr http.ResponseWriter, r *http.Request, status int, code string) {
+	wr.Header().Set("Content-Type", "application/xml")
+	wr.WriteHeader(status)
+
+	if r.Method != http.MethodHead {
+		fmt.Fprintf(wr, "<Error><Code>%s</Code></Error>", code)
+	}
+}
+
+// s3MockBucket is a bucket that uploads and downloads the objects in a
+// single request
+type s3MockBucket struct {
+	client *s3.Client
+	name   string
+}
+
+func (b s3MockBucket) Put(ctx context.Context, key string) io.WriteCloser {
+	return &s3MockWriter{ctx: ctx, bucket: b, key: key}
+}
+
+func (b s3MockBucket) Get(ctx context.Context, key string) io.Reader {
+	out, err := b.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.name),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return &errReader{err}
+	}
+
+	return out.Body
+}
+
+func (b s3MockBucket) Client() *s3.Client {
+	return b.client
+}
+
+func (b s3MockBucket) Name() string {
+	return b.name
+}
+
+// s3MockWriter uploads the written data when it's closed
+type s3MockWriter struct {
+	ctx    context.Context
+	bucket s3MockBucket
+	key    string
+	buf    bytes.Buffer
+}
+
+func (w *s3MockWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *s3MockWriter) Close() error {
+	_, err := w.bucket.client.PutObject(w.ctx, &s3.PutObjectInput{
+		Bucket: aws.String(w.bucket.name),
+		Key:    aws.String(w.key),
+		Body:   bytes.NewReader(w.buf.Bytes()),
+	})
+
+	return err
+}
+
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
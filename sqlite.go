@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite" // registers the sqlite driver
+)
+
+// sqliteSchema contains the tables of the state database, the records and
+// partial downloads are stored as json with the columns they're queried by
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS records (
+	account     TEXT NOT NULL,
+	id          TEXT NOT NULL,
+	session_id  TEXT NOT NULL,
+	user_id     TEXT NOT NULL,
+	recorded_at INTEGER NOT NULL,
+	data        TEXT NOT NULL,
+	PRIMARY KEY (account, id)
+);
+CREATE INDEX IF NOT EXISTS records_session ON records (account, session_id);
+CREATE INDEX IF NOT EXISTS records_recorded_at ON records (account, user_id, recorded_at);
+
+CREATE TABLE IF NOT EXISTS partials (
+	account TEXT NOT NULL,
+	id      TEXT NOT NULL,
+	path    TEXT NOT NULL,
+	data    TEXT NOT NULL,
+	PRIMARY KEY (account, id, path)
+);
+
+CREATE TABLE IF NOT EXISTS migrations (
+	account     TEXT NOT NULL PRIMARY KEY,
+	migrated_at INTEGER NOT NULL
+);
+`
+
+// sqliteStore keeps the state in a sqlite database, the accounts can share
+// the database since every row belongs to an account
+type sqliteStore struct {
+	db      *sql.DB
+	account string
+}
+
+func openSQLiteStore(ctx context.Context, path, account string) (*sqliteStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("unable to open state database: %w", err)
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open state database: %w", err)
+	}
+
+	// a single connection keeps the pragmas and serializes the writes
+	db.SetMaxOpenConns(1)
+
+	for _, stmt := range []string{"PRAGMA busy_timeout = 5000", "PRAGMA journal_mode = WAL", sqliteSchema} {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			db.Close() //nolint: errcheck
+			return nil, fmt.Errorf("unable to setup state database: %w", err)
+		}
+	}
+
+	return &sqliteStore{db: db, account: account}, nil
+}
+
+// openReadOnlySQLiteStore opens the state database read only, migrated is
+// false when the database or the records of the account don't exist yet
+func openReadOnlySQLiteStore(ctx context.Context, path, account string) (store *sqliteStore, migrated bool, err error) {
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("unable to open state database: %w", err)
+	}
+
+	// opening a database in wal mode creates the wal and shm files unless
+	// it's immutable, without a wal file no one is writing to it
+	dsn := "file:" + path + "?mode=ro"
+	if _, err := os.Stat(path + "-wal"); errors.Is(err, fs.ErrNotExist) {
+		dsn += "&immutable=1"
+	}
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to open state database: %w", err)
+	}
+
+	db.SetMaxOpenConns(1)
+
+	var count int
+	if _, err = db.ExecContext(ctx, "PRAGMA busy_timeout = 5000"); err == nil {
+		err = db.QueryRowContext(ctx, `SELECT COUNT(*) FROM migrations WHERE account = ?`, account).Scan(&count)
+	}
+
+	if err != nil || count == 0 {
+		db.Close() //nolint: errcheck
+		if err != nil {
+			return nil, false, fmt.Errorf("unable to read state database: %w", err)
+		}
+
+		return nil, false, nil
+	}
+
+	return &sqliteStore{db: db, account: account}, true, nil
+}
+
+// migrate imports the saved records file of the account once, the file is
+// kept in the destinations
+func (s *sqliteStore) migrate(ctx context.Context, load func() (*RecordHolder, error), logger *log.Logger) error {
+	var migrated int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM migrations WHERE account = ?`, s.account).Scan(&migrated)
+	if err != nil || migrated > 0 {
+		return err
+	}
+
+	records, err := load()
+	if err != nil {
+		return fmt.Errorf("unable to migrate the saved records: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint: errcheck
+
+	for _, rec := range records.Records {
+		if err := saveRecord(ctx, tx, s.account, rec); err != nil {
+			return err
+		}
+	}
+
+	for _, partial := range records.Partials {
+		if err := savePartial(ctx, tx, s.account, partial); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `INSERT INTO migrations (account, migrated_at) VALUES (?, ?)`, s.account, time.Now().Unix()); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if len(records.Records) > 0 {
+		logger.Printf("migrated %d saved records to the state database", len(records.Records))
+	}
+
+	return nil
+}
+
+func (s *sqliteStore) Record(ctx context.Context, id string) (SavedRecord, bool, error) {
+	records, err := s.records(ctx, `WHERE account = ? AND id = ?`, s.account, id)
+	if err != nil || len(records) == 0 {
+		return SavedRecord{}, false, err
+	}
+
+	return records[0], true, nil
+}
+
+func (s *sqliteStore) Records(ctx context.Context) ([]SavedRecord, error) {
+	return s.records(ctx, `WHERE account = ? ORDER BY recorded_at`, s.account)
+}
+
+func (s *sqliteStore) UserRecords(ctx context.Context, userID string, since time.Time) ([]SavedRecord, error) {
+	return s.records(ctx, `WHERE account = ? AND user_id = ? AND recorded_at >= ? ORDER BY recorded_at`,
+		s.account, userID, unixNano(since))
+}
+
+func (s *sqliteStore) LastRecorded(ctx context.Context, userID string) (time.Time, error) {
+	return s.recordedAt(ctx, `SELECT MAX(recorded_at) FROM records WHERE account = ? AND user_id = ?`, s.account, userID)
+}
+
+func (s *sqliteStore) FirstUndeleted(ctx context.Context, userID string) (time.Time, error) {
+	return s.recordedAt(ctx, `SELECT MIN(recorded_at) FROM records
+		WHERE account = ? AND user_id = ? AND json_extract(data, '$.deleted_at') IS NULL`, s.account, userID)
+}
+
+// recordedAt returns the recording time selected by the query, the zero
+// time when no record matches
+func (s *sqliteStore) recordedAt(ctx context.Context, query string, args ...any) (time.Time, error) {
+	var nanos sql.NullInt64
+	if err := s.db.QueryRowContext(ctx, query, args...).Scan(&nanos); err != nil || !nanos.Valid || nanos.Int64 == 0 {
+		return time.Time{}, err
+	}
+
+	return time.Unix(0, nanos.Int64).UTC(), nil
+}
+
+func (s *sqliteStore) SessionRecords(ctx context.Context, sessionID string) ([]SavedRecord, error) {
+	return s.records(ctx, `WHERE account = ? AND session_id = ? ORDER BY recorded_at`, s.account, sessionID)
+}
+
+// records returns the records matching the where clause
+func (s *sqliteStore) records(ctx context.Context, where string, args ...any) ([]SavedRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT data FROM records `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() //nolint: errcheck
+
+	records := []SavedRecord{}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		rec := SavedRecord{}
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, fmt.Errorf("invalid record: %w", err)
+		}
+
+		records = append(records, rec)
+	}
+
+	return records, rows.Err()
+}
+
+func (s *sqliteStore) SaveRecord(ctx context.Context, rec SavedRecord) error {
+	return saveRecord(ctx, s.db, s.account, rec)
+}
+
+func (s *sqliteStore) Partial(ctx context.Context, id, path string) (PartialDownload, bool, error) {
+	partials, err := s.partials(ctx, `WHERE account = ? AND id = ? AND path = ?`, s.account, id, path)
+	if err != nil || len(partials) == 0 {
+		return PartialDownload{}, false, err
+	}
+
+	return partials[0], true, nil
+}
+
+func (s *sqliteStore) Partials(ctx context.Context) ([]PartialDownload, error) {
+	return s.partials(ctx, `WHERE account = ?`, s.account)
+}
+
+func (s *sqliteStore) partials(ctx context.Context, where string, args ...any) ([]PartialDownload, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT data FROM partials `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() //nolint: errcheck
+
+	partials := []PartialDownload{}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		partial := PartialDownload{}
+		if err := json.Unmarshal(data, &partial); err != nil {
+			return nil, fmt.Errorf("invalid partial download: %w", err)
+		}
+
+		partials = append(partials, partial)
+	}
+
+	return partials, rows.Err()
+}
+
+func (s *sqliteStore) SavePartial(ctx context.Context, partial PartialDownload) error {
+	return savePartial(ctx, s.db, s.account, partial)
+}
+
+func (s *sqliteStore) DropPartial(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM partials WHERE account = ? AND id = ?`, s.account, id)
+	return err
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
+
+// execer is a database or a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func saveRecord(ctx context.Context, db execer, account string, rec SavedRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, `INSERT INTO records (account, id, session_id, user_id, recorded_at, data)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (account, id) DO UPDATE SET
+			session_id = excluded.session_id,
+			user_id = excluded.user_id,
+			recorded_at = excluded.recorded_at,
+			data = excluded.data`,
+		account, rec.ID, rec.SessionID, rec.UserID, unixNano(rec.RecordedAt), data)
+
+	return err
+}
+
+func savePartial(ctx context.Context, db execer, account string, partial PartialDownload) error {
+	data, err := json.Marshal(partial)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, `INSERT INTO partials (account, id, path, data) VALUES (?, ?, ?, ?)
+		ON CONFLICT (account, id, path) DO UPDATE SET data = excluded.data`,
+		account, partial.ID, partial.Path, data)
+
+	return err
+}
+
+// unixNano returns the time as unix nanoseconds, the zero time is 0 since
+// its unix nanoseconds are out of range
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixNano()
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// StateStore keeps the saved records and the partial downloads of an account
+type StateStore interface {
+	// Record returns the saved record of the recording file
+	Record(ctx context.Context, id string) (SavedRecord, bool, error)
+	// Records returns every saved record ordered by recording time
+	Records(ctx context.Context) ([]SavedRecord, error)
+	// UserRecords returns the saved records of the user recorded since the
+	// given time ordered by recording time
+	UserRecords(ctx context.Context, userID string, since time.Time) ([]SavedRecord, error)
+	// LastRecorded returns the recording time of the latest record of the
+	// user, the zero time without records
+	LastRecorded(ctx context.Context, userID string) (time.Time, error)
+	// FirstUndeleted returns the recording time of the oldest record of the
+	// user that isn't deleted from zoom, the zero time when every record is
+	FirstUndeleted(ctx context.Context, userID string) (time.Time, error)
+	// SessionRecords returns the saved records of the meeting
+	SessionRecords(ctx context.Context, sessionID string) ([]SavedRecord, error)
+	// SaveRecord adds the record or replaces the record with the same id
+	SaveRecord(ctx context.Context, rec SavedRecord) error
+
+	// Partial returns the partial download of the recording file to the path
+	Partial(ctx context.Context, id, path string) (PartialDownload, bool, error)
+	// Partials returns every partial download
+	Partials(ctx context.Context) ([]PartialDownload, error)
+	// SavePartial adds or replaces the partial download
+	SavePartial(ctx context.Context, partial PartialDownload) error
+	// DropPartial removes the partial downloads of the recording file
+	DropPartial(ctx context.Context, id string) error
+
+	// Close writes the pending changes and releases the store
+	Close() error
+}
+
+// openState opens the state store of the account, this is the saved records
+// file in the destinations unless a state database is configured
+func (z *ZoomClient) openState(ctx context.Context) (StateStore, error) {
+	if z.config.StateDB == "" {
+		records, err := z.LoadRecords()
+		if err != nil {
+			return nil, err
+		}
+
+		return newJSONStore(ctx, z, records), nil
+	}
+
+	store, err := openSQLiteStore(ctx, z.config.StateDB, z.config.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := store.migrate(ctx, z.LoadRecords, z.logger); err != nil {
+		store.Close() //nolint: errcheck
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// openReadOnlyState opens the state store without changing it, the saved
+// records file is used as long as the state database doesn't have the
+// records of the account since the migration would change it
+func (z *ZoomClient) openReadOnlyState(ctx context.Context) (StateStore, error) {
+	if z.config.StateDB != "" {
+		store, migrated, err := openReadOnlySQLiteStore(ctx, z.config.StateDB, z.config.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if migrated {
+			return store, nil
+		}
+	}
+
+	records, err := z.LoadRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	return newJSONStore(ctx, z, records), nil
+}
+
+// closeState closes the state store and adds the error to err, the changes
+// of the operation are lost when the state can't be saved
+func (z *ZoomClient) closeState(state StateStore, err *error) {
+	if cerr := state.Close(); cerr != nil {
+		*err = errors.Join(*err, fmt.Errorf("unable to save the state: %w", cerr))
+	}
+}
+
+// jsonStore keeps the records in the saved records file of the destinations,
+// the file is read when it's opened and written on Close when it's changed
+type jsonStore struct {
+	ctx     context.Context
+	z       *ZoomClient
+	records *RecordHolder
+	ids     map[string]int // index of the records by id
+	changed bool
+}
+
+func newJSONStore(ctx context.Context, z *ZoomClient, records *RecordHolder) *jsonStore {
+	s := &jsonStore{ctx: ctx, z: z, records: records, ids: map[string]int{}}
+	for i, rec := range records.Records {
+		s.ids[rec.ID] = i
+	}
+
+	return s
+}
+
+func (s *jsonStore) Record(_ context.Context, id string) (SavedRecord, bool, error) {
+	i, ok := s.ids[id]
+	if !ok {
+		return SavedRecord{}, false, nil
+	}
+
+	return s.records.Records[i], true, nil
+}
+
+func (s *jsonStore) Records(context.Context) ([]SavedRecord, error) {
+	return s.filter(func(SavedRecord) bool { return true }), nil
+}
+
+func (s *jsonStore) UserRecords(_ context.Context, userID string, since time.Time) ([]SavedRecord, error) {
+	return s.filter(func(rec SavedRecord) bool {
+		return rec.UserID == userID && !rec.RecordedAt.Before(since)
+	}), nil
+}
+
+func (s *jsonStore) LastRecorded(_ context.Context, userID string) (time.Time, error) {
+	var last time.Time
+	for _, rec := range s.records.Records {
+		if rec.UserID == userID {
+			last = latest(last, rec.RecordedAt)
+		}
+	}
+
+	return last, nil
+}
+
+func (s *jsonStore) FirstUndeleted(_ context.Context, userID string) (time.Time, error) {
+	var first time.Time
+	for _, rec := range s.records.Records {
+		if rec.UserID == userID && rec.DeletedAt.IsZero() && (first.IsZero() || rec.RecordedAt.Before(first)) {
+			first = rec.RecordedAt
+		}
+	}
+
+	return first, nil
+}
+
+func (s *jsonStore) SessionRecords(_ context.Context, sessionID string) ([]SavedRecord, error) {
+	return s.filter(func(rec SavedRecord) bool {
+		return rec.SessionID == sessionID
+	}), nil
+}
+
+// filter returns the matching records ordered by recording time
+func (s *jsonStore) filter(match func(SavedRecord) bool) []SavedRecord {
+	records := []SavedRecord{}
+	for _, rec := range s.records.Records {
+		if match(rec) {
+			records = append(records, rec)
+		}
+	}
+
+	slices.SortStableFunc(records, func(a, b SavedRecord) int {
+		return a.RecordedAt.Compare(b.RecordedAt)
+	})
+
+	return records
+}
+
+func (s *jsonStore) SaveRecord(_ context.Context, rec SavedRecord) error {
+	s.changed = true
+	if i, ok := s.ids[rec.ID]; ok {
+		s.records.Records[i] = rec
+		return nil
+	}
+
+	s.ids[rec.ID] = len(s.records.Records)
+	s.records.Records = append(s.records.Records, rec)
+
+	return nil
+}
+
+func (s *jsonStore) Partial(_ context.Context, id, path string) (PartialDownload, bool, error) {
+	for _, partial := range s.records.Partials {
+		if partial.ID == id && partial.Path == path {
+			return partial, true, nil
+		}
+	}
+
+	return PartialDownload{}, false, nil
+}
+
+func (s *jsonStore) Partials(context.Context) ([]PartialDownload, error) {
+	return slices.Clone(s.records.Partials), nil
+}
+
+func (s *jsonStore) SavePartial(_ context.Context, partial PartialDownload) error {
+	s.changed = true
+	for i, p := range s.records.Partials {
+		if p.ID == partial.ID && p.Path == partial.Path {
+			s.records.Partials[i] = partial
+			return nil
+		}
+	}
+
+	s.records.Partials = append(s.records.Partials, partial)
+
+	return nil
+}
+
+func (s *jsonStore) DropPartial(_ context.Context, id string) error {
+	s.records.Partials = slices.DeleteFunc(s.records.Partials, func(p PartialDownload) bool {
+		if p.ID == id {
+			s.changed = true
+			return true
+		}
+
+		return false
+	})
+
+	return nil
+}
+
+func (s *jsonStore) Close() error {
+	if s.changed {
+		if err := s.z.saveRecords(s.ctx, s.records); err != nil {
+			return err
+		}
+
+		s.changed = false
+	}
+
+	return nil
+}
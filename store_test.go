@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestStateStore(t *testing.T) {
+	t.Run("json", func(t *testing.T) {
+		c := SetupTest(t, "tmp_test_state_json")
+
+		state, err := c.openState(context.Background())
+		if err != nil {
+			t.Fatalf("unable to open state: %v", err)
+		}
+
+		testStateStore(t, state)
+
+		records, err := c.LoadRecords()
+		assert(t, err == nil && len(records.Records) == 3, "records file must be written on close")
+		assert(t, len(records.Partials) == 0, "dropped partial downloads must not be saved")
+	})
+
+	t.Run("sqlite", func(t *testing.T) {
+		dir := "tmp_test_state_sqlite"
+		c := SetupTest(t, dir)
+		c.config.StateDB = path.Join(dir, "state.db")
+
+		state, err := c.openState(context.Background())
+		if err != nil {
+			t.Fatalf("unable to open state: %v", err)
+		}
+
+		testStateStore(t, state)
+
+		state, err = c.openState(context.Background())
+		if err != nil {
+			t.Fatalf("unable to reopen state: %v", err)
+		}
+
+		defer state.Close() //nolint: errcheck
+
+		records, err := state.Records(context.Background())
+		assert(t, err == nil && len(records) == 3, "records must be kept in the database")
+
+		other, err := openSQLiteStore(context.Background(), c.config.StateDB, "other")
+		if err != nil {
+			t.Fatalf("unable to open state: %v", err)
+		}
+
+		defer other.Close() //nolint: errcheck
+
+		records, err = other.Records(context.Background())
+		assert(t, err == nil && len(records) == 0, "records of other accounts must not be returned")
+	})
+}
+
+// testStateStore runs the operations of the state store and closes it
+func testStateStore(t *testing.T, state StateStore) {
+	t.Helper()
+
+	ctx := context.Background()
+	day := func(d int) time.Time { return time.Date(2024, time.January, d, 0, 0, 0, 0, time.UTC) }
+
+	for _, rec := range []SavedRecord{
+		{ID: "c", SessionID: "2", UserID: "me", RecordedAt: day(3), Path: "c.mp4"},
+		{ID: "a", SessionID: "1", UserID: "me", RecordedAt: day(1), Path: "a.mp4"},
+		{ID: "b", SessionID: "1", UserID: "you", RecordedAt: day(2), Path: "b.mp4"},
+	} {
+		if err := state.SaveRecord(ctx, rec); err != nil {
+			t.Fatalf("unable to save record: %v", err)
+		}
+	}
+
+	rec, ok, err := state.Record(ctx, "a")
+	assert(t, err == nil && ok && rec.Path == "a.mp4", "saved record must be returned")
+
+	_, ok, err = state.Record(ctx, "unknown")
+	assert(t, err == nil && !ok, "unknown record must not be found")
+
+	rec.DeletedAt = day(4)
+	if err := state.SaveRecord(ctx, rec); err != nil {
+		t.Fatalf("unable to update record: %v", err)
+	}
+
+	records, err := state.Records(ctx)
+	assert(t, err == nil && len(records) == 3, "updated record must replace the saved record")
+	assert(t, records[0].ID == "a" && records[2].ID == "c", "records must be ordered by recording time")
+	assert(t, records[0].DeletedAt.Equal(day(4)), "record must be updated")
+
+	records, err = state.UserRecords(ctx, "me", day(2))
+	assert(t, err == nil && len(records) == 1 && records[0].ID == "c", "user records must be filtered by user and time")
+
+	last, err := state.LastRecorded(ctx, "me")
+	assert(t, err == nil && last.Equal(day(3)), "last recorded must be the latest record of the user")
+
+	first, err := state.FirstUndeleted(ctx, "me")
+	assert(t, err == nil && first.Equal(day(3)), "first undeleted must skip the deleted records")
+
+	first, err = state.FirstUndeleted(ctx, "nobody")
+	assert(t, err == nil && first.IsZero(), "first undeleted must be zero without records")
+
+	records, err = state.SessionRecords(ctx, "1")
+	assert(t, err == nil && len(records) == 2, "session records must be filtered by session")
+
+	partial := PartialDownload{ID: "d", Path: "d.mp4", Offset: 4}
+	if err := state.SavePartial(ctx, partial); err != nil {
+		t.Fatalf("unable to save partial download: %v", err)
+	}
+
+	partial.Offset = 8
+	if err := state.SavePartial(ctx, partial); err != nil {
+		t.Fatalf("unable to update partial download: %v", err)
+	}
+
+	partial, ok, err = state.Partial(ctx, "d", "d.mp4")
+	assert(t, err == nil && ok && partial.Offset == 8, "updated partial download must be returned")
+
+	partials, err := state.Partials(ctx)
+	assert(t, err == nil && len(partials) == 1, "updated partial download must replace the saved one")
+
+	if err := state.DropPartial(ctx, "d"); err != nil {
+		t.Fatalf("unable to drop partial download: %v", err)
+	}
+
+	_, ok, err = state.Partial(ctx, "d", "d.mp4")
+	assert(t, err == nil && !ok, "dropped partial download must not be found")
+
+	if err := state.Close(); err != nil {
+		t.Fatalf("unable to close state: %v", err)
+	}
+}
+
+func TestStateMigration(t *testing.T) {
+	dir := "tmp_test_state_migration"
+	c := SetupTest(t, dir)
+
+	c.config.RecordingTypes = []string{string(RecordingTypeGallery)}
+	c.config.StartingFromYear = 2022
+
+	if err := c.Sweep(); err != nil {
+		t.Fatalf("unexpected error during sweep: %v", err)
+	}
+
+	saved, err := c.LoadRecords()
+	if err != nil {
+		t.Fatalf("unable to load records: %v", err)
+	}
+
+	c.config.StateDB = path.Join(dir, "state.db")
+
+	state, err := c.openState(context.Background())
+	if err != nil {
+		t.Fatalf("unable to open state: %v", err)
+	}
+
+	records, _ := state.Records(context.Background()) //nolint: errcheck
+	assert(t, len(saved.Records) > 0 && len(records) == len(saved.Records), "saved records must be migrated")
+
+	if err := state.Close(); err != nil {
+		t.Fatalf("unable to close state: %v", err)
+	}
+
+	// the records file must not be imported again
+	saved.Records = append(saved.Records, SavedRecord{ID: "new"})
+	if err := c.saveRecords(context.Background(), saved); err != nil {
+		t.Fatalf("unable to save records: %v", err)
+	}
+
+	state, err = c.openState(context.Background())
+	if err != nil {
+		t.Fatalf("unable to reopen state: %v", err)
+	}
+
+	_, ok, _ := state.Record(context.Background(), "new") //nolint: errcheck
+	assert(t, !ok, "saved records must only be migrated once")
+
+	if err := state.Close(); err != nil {
+		t.Fatalf("unable to close state: %v", err)
+	}
+
+	if err := c.Sweep(); err != nil {
+		t.Fatalf("unexpected error during sweep: %v", err)
+	}
+}
+
+func TestSaveStateError(t *testing.T) {
+	dir := "tmp_test_save_state_error"
+	c := SetupTest(t, dir)
+
+	c.config.RecordingTypes = []string{string(RecordingTypeGallery)}
+	c.config.StartingFromYear = 2022
+	c.fs = unsavablefs{c.fs.(*osfs)}
+
+	err := c.Sweep()
+	assert(t, err != nil, "sweep must fail when the records can't be saved")
+
+	assertFileExists(t, path.Join(dir, "static/2022-10-01_00-00-00_gallery_view.mp4"))
+}
+
+// unsavablefs is a destination where the records file can't be written
+type unsavablefs struct {
+	*osfs
+}
+
+func (f unsavablefs) Writer(ctx context.Context, target string) (FileWriter, error) {
+	if target == SavedRecordFileName {
+		return nil, errors.New("destination full")
+	}
+
+	return f.osfs.Writer(ctx, target)
+}
+
+func TestPlanReadOnlyState(t *testing.T) {
+	dir := "tmp_test_plan_read_only_state"
+	c := SetupTest(t, dir)
+
+	c.config.RecordingTypes = []string{string(RecordingTypeGallery)}
+	c.config.StartingFromYear = 2022
+	c.config.StateDB = path.Join(t.TempDir(), "state.db")
+
+	if _, err := c.Plan(); err != nil {
+		t.Fatalf("unexpected error planning sweep: %v", err)
+	}
+
+	assertFileNotExists(t, c.config.StateDB)
+	assertFileNotExists(t, dir)
+
+	if err := c.Sweep(); err != nil {
+		t.Fatalf("unexpected error during sweep: %v", err)
+	}
+
+	listState := func() map[string]time.Time {
+		entries, _ := os.ReadDir(path.Dir(c.config.StateDB)) //nolint: errcheck
+		files := map[string]time.Time{}
+		for _, entry := range entries {
+			info, _ := entry.Info() //nolint: errcheck
+			files[entry.Name()] = info.ModTime()
+		}
+
+		return files
+	}
+
+	before := listState()
+
+	plan, err := c.Plan()
+	assert(t, err == nil && len(plan.Downloads) == 0, "plan must use the state database")
+
+	c.config.Name = "other"
+	plan, err = c.Plan()
+	assert(t, err == nil && len(plan.Downloads) > 0, "plan must use the saved records file before the migration")
+
+	after := listState()
+	assert(t, maps.Equal(before, after), "plan must not change the state database", fmt.Sprint(before), fmt.Sprint(after))
+
+	_, migrated, err := openReadOnlySQLiteStore(context.Background(), c.config.StateDB, "other")
+	assert(t, err == nil && !migrated, "plan must not migrate the saved records")
+}
@@ -0,0 +1,736 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RecordHolder holds stores the saved records
+type RecordHolder struct {
+	Records   []SavedRecord
+	Partials  []PartialDownload `json:",omitempty"`
+	UpdatedAt time.Time         `json:",omitzero"`
+}
+
+// lastUpdate returns when the records were saved, for records files without
+// the update time it's the last time a record was saved or deleted
+func (r *RecordHolder) lastUpdate() time.Time {
+	if !r.UpdatedAt.IsZero() {
+		return r.UpdatedAt
+	}
+
+	var last time.Time
+	for _, rec := range r.Records {
+		last = latest(latest(last, rec.SavedAt), rec.DeletedAt)
+	}
+
+	return last
+}
+
+// merge adds the records that are missing in the records
+func (r *RecordHolder) merge(other *RecordHolder) {
+	saved := savedRecordMap(r)
+	for _, rec := range other.Records {
+		if _, ok := saved[rec.ID]; !ok {
+			r.Records = append(r.Records, rec)
+		}
+	}
+}
+
+// state returns the state of the file in the destination
+func (r *SavedRecord) state(destination string) (DestinationState, bool) {
+	i := slices.IndexFunc(r.Destinations, func(s DestinationState) bool {
+		return s.Destination == destination
+	})
+	if i < 0 {
+		return DestinationState{}, false
+	}
+
+	return r.Destinations[i], true
+}
+
+// setState replaces the state of the file in the destination
+func (r *SavedRecord) setState(state DestinationState) {
+	i := slices.IndexFunc(r.Destinations, func(s DestinationState) bool {
+		return s.Destination == state.Destination
+	})
+	if i < 0 {
+		r.Destinations = append(r.Destinations, state)
+		return
+	}
+
+	r.Destinations[i] = state
+}
+
+// failedDestinations returns the destinations the file couldn't be written to
+func (r *SavedRecord) failedDestinations() []string {
+	failed := []string{}
+	for _, state := range r.Destinations {
+		if !state.OK {
+			failed = append(failed, state.Destination)
+		}
+	}
+
+	return failed
+}
+
+// SweepPlan contains the downloads and deletions of a sweep
+type SweepPlan struct {
+	Account    string            `json:"account,omitempty"`
+	DeleteMode string            `json:"delete_mode,omitempty"`
+	Downloads  []PlannedDownload `json:"downloads"`
+	Deletions  []PlannedDeletion `json:"deletions"`
+	Retained   []RetainedMeeting `json:"retained"`
+
+	removed []SavedRecord     // records whose recording file is not in zoom anymore
+	targets map[string]string // recording file ids by the planned path
+}
+
+// PlannedDownload is a recording file that will be downloaded
+type PlannedDownload struct {
+	MeetingID   int           `json:"meeting_id"`
+	MeetingUUID string        `json:"meeting_uuid"`
+	Topic       string        `json:"topic"`
+	UserID      string        `json:"user_id,omitempty"`
+	UserEmail   string        `json:"user_email,omitempty"`
+	Target      string        `json:"target"`
+	Route       string        `json:"route,omitempty"`
+	File        RecordingFile `json:"file"`
+
+	fs FileSystem // destinations of the route
+}
+
+// PlannedDeletion is a meeting whose recordings will be deleted from zoom
+// once all its (allowed) recording files are verified in the destinations
+type PlannedDeletion struct {
+	MeetingID   int             `json:"meeting_id"`
+	MeetingUUID string          `json:"meeting_uuid"`
+	Topic       string          `json:"topic"`
+	Files       []RecordingFile `json:"files"`
+}
+
+// RetainedMeeting is a meeting that is not deleted from zoom
+type RetainedMeeting struct {
+	MeetingID   int    `json:"meeting_id"`
+	MeetingUUID string `json:"meeting_uuid"`
+	Topic       string `json:"topic"`
+	Reason      string `json:"reason"`
+}
+
+// Sweep will get all the records and download the specified files
+func (z *ZoomClient) Sweep() (err error) {
+	ctx, done := z.begin()
+	defer done()
+
+	state, err := z.openState(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer z.closeState(state, &err)
+
+	plan, err := z.plan(state)
+
+	errs := errors.Join(err, z.execute(plan, state))
+	z.logger.Print(`finished fetching recordings`)
+
+	return errs
+}
+
+// Plan returns what the next sweep will download and delete without
+// writing to the destinations or the state or deleting anything
+func (z *ZoomClient) Plan() (plan *SweepPlan, err error) {
+	ctx, done := z.begin()
+	defer done()
+
+	state, err := z.openReadOnlyState(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer z.closeState(state, &err)
+
+	return z.plan(state)
+}
+
+// DownloadMeeting downloads the allowed recording files of a single meeting
+func (z *ZoomClient) DownloadMeeting(meetingUUID string) (err error) {
+	ctx, done := z.begin()
+	defer done()
+
+	state, err := z.openState(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer z.closeState(state, &err)
+
+	filters, err := z.config.Filters()
+	if err != nil {
+		return err
+	}
+
+	paths, err := z.config.Paths()
+	if err != nil {
+		return err
+	}
+
+	meeting, err := z.GetMeetingRecordings(meetingUUID)
+	if err != nil {
+		return err
+	}
+
+	user := User{}
+	if z.config.AllUsers {
+		user, err = z.GetUser(meeting.HostID)
+		if err != nil {
+			return err
+		}
+	}
+
+	plan := &SweepPlan{Account: z.config.Name}
+	if err := z.planDownloads(plan, user, meeting, state, filters, paths); err != nil {
+		return err
+	}
+
+	return z.execute(plan, state)
+}
+
+// begin starts an operation with its own context, the returned function
+// cancels the context and restores the previous one
+func (z *ZoomClient) begin() (context.Context, func()) {
+	prev := z.context
+	ctx, cancel := context.WithCancel(context.Background())
+	z.context = ctx
+
+	return ctx, func() {
+		cancel()
+		z.context = prev
+	}
+}
+
+// LoadRecords reads the saved records of the account from every destination,
+// the most recent valid records file is completed with the records that are
+// only in the records files of the other destinations
+func (z *ZoomClient) LoadRecords() (*RecordHolder, error) {
+	var (
+		found []*RecordHolder
+		errs  error
+	)
+
+	for _, dst := range destinations(z.fs) {
+		records, err := z.readRecords(dst)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case errors.Is(err, errInvalidRecords):
+			z.logger.Printf("unable to read record file of %s: %v", dst.name, err)
+		case err != nil:
+			errs = errors.Join(errs, fmt.Errorf("%s: %w", dst.name, err))
+		default:
+			found = append(found, records)
+		}
+	}
+
+	// the records file can be in the destination that failed, without
+	// records the sweep would download everything again
+	if len(found) == 0 && errs != nil {
+		return nil, fmt.Errorf("no records file found and not every destination is available: %w", errs)
+	}
+
+	if errs != nil {
+		z.logger.Printf("unable to read record file: %v", errs)
+	}
+
+	if len(found) == 0 {
+		return &RecordHolder{}, nil
+	}
+
+	slices.SortStableFunc(found, func(a, b *RecordHolder) int {
+		return b.lastUpdate().Compare(a.lastUpdate())
+	})
+
+	records := found[0]
+	for _, other := range found[1:] {
+		records.merge(other)
+	}
+
+	return records, nil
+}
+
+// errInvalidRecords is returned for a records file that can't be decoded
+var errInvalidRecords = errors.New("invalid records file")
+
+// readRecords reads the records file of the destination
+func (z *ZoomClient) readRecords(dst destination) (*RecordHolder, error) {
+	saveFile, err := dst.Reader(z.context, z.recordsFile())
+	if err != nil {
+		return nil, err
+	}
+
+	if closer, ok := saveFile.(io.Closer); ok {
+		defer closer.Close() //nolint: errcheck
+	}
+
+	records := &RecordHolder{}
+	if err := json.NewDecoder(saveFile).Decode(records); err == io.EOF {
+		return nil, fmt.Errorf("%w: empty file", errInvalidRecords)
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidRecords, err)
+	}
+
+	return records, nil
+}
+
+// SweepUsers returns the users whose recordings are swept, this is only
+// the authorized user unless all users of the account are swept
+func (z *ZoomClient) SweepUsers() ([]User, error) {
+	if !z.config.AllUsers {
+		return []User{{}}, nil
+	}
+
+	z.logger.Print(`pulling users`)
+	users, err := z.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	filters, err := z.config.Filters()
+	if err != nil {
+		return nil, err
+	}
+
+	users = filterUsers(users, filters)
+	z.logger.Printf("fetched %d users", len(users))
+
+	return users, nil
+}
+
+// plan lists the recordings of the users and returns what should be
+// downloaded and deleted, the plan contains the users that succeeded
+// when an error is returned
+func (z *ZoomClient) plan(state StateStore) (*SweepPlan, error) {
+	plan := &SweepPlan{Account: z.config.Name, DeleteMode: z.config.DeleteMode}
+
+	filters, err := z.config.Filters()
+	if err != nil {
+		return plan, err
+	}
+
+	paths, err := z.config.Paths()
+	if err != nil {
+		return plan, err
+	}
+
+	users, err := z.SweepUsers()
+	if err != nil {
+		return plan, err
+	}
+
+	var errs error
+	for _, user := range users {
+		if err := z.planUser(plan, user, state, filters, paths); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
+
+	return plan, errs
+}
+
+func (z *ZoomClient) planUser(plan *SweepPlan, user User, state StateStore, filters *Filters, paths *PathTemplate) error {
+	from, err := state.LastRecorded(z.context, user.ID)
+	if err != nil {
+		return err
+	}
+
+	// list the meetings again that are archived but not deleted yet
+	if z.config.DeleteAfter {
+		pending, err := state.FirstUndeleted(z.context, user.ID)
+		if err != nil {
+			return err
+		}
+
+		if !pending.IsZero() {
+			from = pending
+		}
+	}
+
+	if user.Email != "" {
+		z.logger.Printf("pulling recordings of %s", user.Email)
+	} else {
+		z.logger.Print(`pulling recordings`)
+	}
+	meetings, err := z.ListUserRecordings(cmp.Or(user.ID, "me"), from)
+	if err != nil {
+		return err
+	}
+
+	z.logger.Printf("fetched %d entries", len(meetings))
+
+	if z.config.DeleteAfter {
+		records, err := state.UserRecords(z.context, user.ID, from)
+		if err != nil {
+			return err
+		}
+
+		plan.removed = append(plan.removed, removedRecordings(records, meetings, from)...)
+	}
+
+	for _, meeting := range meetings {
+		if included, reason := filters.IncludedMeeting(meeting); !included {
+			if z.config.DeleteAfter {
+				plan.retain(meeting.ID, meeting.UUID, meeting.Topic, reason)
+			}
+
+			continue
+		}
+
+		if err := z.planDownloads(plan, user, meeting, state, filters, paths); err != nil {
+			return err
+		}
+
+		if z.config.DeleteAfter {
+			plan.Deletions = append(plan.Deletions, PlannedDeletion{
+				MeetingID:   meeting.ID,
+				MeetingUUID: meeting.UUID,
+				Topic:       meeting.Topic,
+				Files:       filters.AllowedFiles(meeting.RecordingFiles),
+			})
+		}
+	}
+
+	return nil
+}
+
+// removedRecordings returns the records since from that aren't deleted yet
+// but whose recording file is not in zoom anymore (e.g. deleted by hand)
+func removedRecordings(records []SavedRecord, meetings []Meeting, from time.Time) []SavedRecord {
+	listed := map[string]bool{}
+	for _, meeting := range meetings {
+		for _, rf := range meeting.RecordingFiles {
+			listed[rf.ID] = true
+		}
+	}
+
+	removed := []SavedRecord{}
+	for _, rec := range records {
+		if rec.DeletedAt.IsZero() && !rec.RecordedAt.Before(from) && !listed[rec.ID] {
+			removed = append(removed, rec)
+		}
+	}
+
+	return removed
+}
+
+// retain adds the meeting with the reason it's not deleted to the plan
+func (p *SweepPlan) retain(meetingID int, meetingUUID, topic, reason string) {
+	p.Retained = append(p.Retained, RetainedMeeting{
+		MeetingID:   meetingID,
+		MeetingUUID: meetingUUID,
+		Topic:       topic,
+		Reason:      reason,
+	})
+}
+
+// planDownloads adds the allowed recording files of the meeting that
+// are not archived yet to the plan
+func (z *ZoomClient) planDownloads(plan *SweepPlan, user User, meeting Meeting, state StateStore, filters *Filters, paths *PathTemplate) error {
+	for _, rf := range meeting.RecordingFiles {
+		if !filters.AllowedFile(rf) {
+			continue
+		}
+
+		if _, saved, err := state.Record(z.context, rf.ID); err != nil {
+			return err
+		} else if saved {
+			continue
+		}
+
+		target, err := paths.Path(user, meeting, rf)
+		if err != nil {
+			return err
+		}
+
+		// the path template is validated but can still give two files the
+		// same path, the file is kept in zoom instead of being overwritten
+		if other, exists := plan.targets[target]; exists {
+			z.logger.Printf("not downloading %s of '%s': recording file %s has the same path %s", rf.ID, meeting.Topic, other, target)
+			continue
+		}
+
+		if plan.targets == nil {
+			plan.targets = map[string]string{}
+		}
+
+		plan.targets[target] = rf.ID
+
+		fs, route := z.route(meeting, rf)
+		plan.Downloads = append(plan.Downloads, PlannedDownload{
+			MeetingID:   meeting.ID,
+			MeetingUUID: meeting.UUID,
+			Topic:       meeting.Topic,
+			UserID:      user.ID,
+			UserEmail:   user.Email,
+			Target:      target,
+			Route:       route,
+			File:        rf,
+			fs:          fs,
+		})
+	}
+
+	return nil
+}
+
+// execute downloads and deletes the recordings of the plan and adds
+// the downloaded files to the records
+func (z *ZoomClient) execute(plan *SweepPlan, state StateStore) error {
+	errs := markDeleted(z.context, state, plan.removed)
+
+	for _, dl := range plan.Downloads {
+		partial, _, err := state.Partial(z.context, dl.File.ID, dl.Target)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+
+		partial.ID, partial.Path = dl.File.ID, dl.Target
+
+		z.logger.Printf("Downloading '%s' from %v of type %s", dl.Topic, dl.File.RecordingStart, dl.File.RecordingType)
+		result, err := z.download(dl.fs, dl.Target, dl.File, &partial)
+		if err != nil {
+			errs = errors.Join(errs, err, state.SavePartial(z.context, partial))
+			continue
+		}
+
+		if err := state.DropPartial(z.context, dl.File.ID); err != nil {
+			errs = errors.Join(errs, err)
+		}
+
+		rec := SavedRecord{
+			ID:         dl.File.ID,
+			SessionID:  dl.MeetingUUID,
+			UserID:     dl.UserID,
+			UserEmail:  dl.UserEmail,
+			Path:       dl.Target,
+			SavedAt:    time.Now(),
+			RecordedAt: dl.File.RecordingStart,
+			Size:       result.size,
+			SHA256:     result.sum,
+		}
+
+		for _, dst := range destinations(dl.fs) {
+			if err, failed := result.failed[dst.name]; failed {
+				z.logger.Printf("unable to write %s to %s, queued for repair: %v", dl.Target, dst.name, err)
+				rec.setState(DestinationState{Destination: dst.name, Error: err.Error()})
+				continue
+			}
+
+			rec.setState(DestinationState{
+				Destination: dst.name,
+				OK:          true,
+				WrittenAt:   rec.SavedAt,
+				Size:        result.size,
+				SHA256:      result.sum,
+			})
+		}
+
+		if err := state.SaveRecord(z.context, rec); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
+
+	for _, del := range plan.Deletions {
+		if z.config.DeleteMode == "file" {
+			errs = errors.Join(errs, z.deleteFiles(plan, del, state))
+			continue
+		}
+
+		reason, err := z.verifyArchived(del, state)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+
+		if reason != "" {
+			z.logger.Printf("Not deleting '%s' (%s): %s", del.Topic, del.MeetingUUID, reason)
+			plan.retain(del.MeetingID, del.MeetingUUID, del.Topic, reason)
+			continue
+		}
+
+		z.logger.Printf("Deleting '%s' (%s)", del.Topic, z.config.DeleteAction)
+		if err := z.DeleteRecording(del.MeetingUUID); err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+
+		records, err := state.SessionRecords(z.context, del.MeetingUUID)
+		errs = errors.Join(errs, err, markDeleted(z.context, state, records))
+	}
+
+	return errs
+}
+
+// deleteFiles deletes the archived recording files of the meeting one by one,
+// the recording files of the other types stay in zoom
+func (z *ZoomClient) deleteFiles(plan *SweepPlan, del PlannedDeletion, state StateStore) error {
+	if len(del.Files) == 0 {
+		z.logger.Printf("Not deleting '%s' (%s): no recording files are archived", del.Topic, del.MeetingUUID)
+		plan.retain(del.MeetingID, del.MeetingUUID, del.Topic, "no recording files are archived")
+		return nil
+	}
+
+	var errs error
+	for _, rf := range del.Files {
+		rec, saved, err := state.Record(z.context, rf.ID)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+
+		if reason := z.verifyFile(rf, rec, saved); reason != "" {
+			z.logger.Printf("Not deleting %s of '%s' (%s): %s", rf.RecordingType, del.Topic, del.MeetingUUID, reason)
+			plan.retain(del.MeetingID, del.MeetingUUID, del.Topic, reason)
+			continue
+		}
+
+		z.logger.Printf("Deleting %s of '%s' (%s)", rf.RecordingType, del.Topic, z.config.DeleteAction)
+		if err := z.DeleteRecordingFile(del.MeetingUUID, rf.ID); err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+
+		errs = errors.Join(errs, markDeleted(z.context, state, []SavedRecord{rec}))
+	}
+
+	return errs
+}
+
+// markDeleted sets the deletion time of the records that aren't deleted yet
+func markDeleted(ctx context.Context, state StateStore, records []SavedRecord) error {
+	now := time.Now()
+
+	var errs error
+	for _, rec := range records {
+		if rec.DeletedAt.IsZero() {
+			rec.DeletedAt = now
+			errs = errors.Join(errs, state.SaveRecord(ctx, rec))
+		}
+	}
+
+	return errs
+}
+
+// verifyArchived checks if every allowed recording file of the meeting is
+// saved and present in every destination with the size zoom reports,
+// it returns the reason why the meeting can't be deleted otherwise
+func (z *ZoomClient) verifyArchived(del PlannedDeletion, state StateStore) (string, error) {
+	if len(del.Files) == 0 {
+		return "no recording files are archived", nil
+	}
+
+	for _, rf := range del.Files {
+		rec, saved, err := state.Record(z.context, rf.ID)
+		if err != nil {
+			return "", err
+		}
+
+		if reason := z.verifyFile(rf, rec, saved); reason != "" {
+			return reason, nil
+		}
+	}
+
+	return "", nil
+}
+
+// verifyFile checks if the recording file is saved, out of the grace period
+// and present in every destination with the size zoom reports
+func (z *ZoomClient) verifyFile(rf RecordingFile, rec SavedRecord, saved bool) string {
+	if !saved {
+		return fmt.Sprintf("%s recording %s is not archived", rf.RecordingType, rf.ID)
+	}
+
+	gracePeriod := time.Duration(z.config.DeleteAfterDays) * 24 * time.Hour
+	if time.Since(rec.SavedAt) < gracePeriod {
+		return fmt.Sprintf("%s was archived less than %d days ago", rec.Path, z.config.DeleteAfterDays)
+	}
+
+	if failed := rec.failedDestinations(); len(failed) > 0 {
+		return fmt.Sprintf("%s is waiting to be repaired in %s", rec.Path, strings.Join(failed, ", "))
+	}
+
+	info, err := z.recordFS(rec).Stat(z.context, rec.Path)
+	if err != nil {
+		return fmt.Sprintf("%s is not confirmed in the destinations: %v", rec.Path, err)
+	}
+
+	if rf.FileSize > 0 && info.Size != rf.FileSize {
+		return fmt.Sprintf("%s has size %d but zoom reports %d", rec.Path, info.Size, rf.FileSize)
+	}
+
+	if rec.Size > 0 && info.Size != rec.Size {
+		return fmt.Sprintf("%s has size %d but %d bytes were downloaded", rec.Path, info.Size, rec.Size)
+	}
+
+	return ""
+}
+
+// savedRecordMap returns the saved records by recording file id
+func savedRecordMap(records *RecordHolder) map[string]SavedRecord {
+	saved := make(map[string]SavedRecord, len(records.Records))
+	for _, rec := range records.Records {
+		saved[rec.ID] = rec
+	}
+
+	return saved
+}
+
+// filterUsers returns the users that are allowed by the filters
+func filterUsers(users []User, filters *Filters) []User {
+	filtered := make([]User, 0, len(users))
+	for _, user := range users {
+		if filters.AllowedUser(user.Email) {
+			filtered = append(filtered, user)
+		}
+	}
+
+	return filtered
+}
+
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+
+	return a
+}
+
+// saveRecords writes the records file to the destinations
+func (z *ZoomClient) saveRecords(ctx context.Context, records *RecordHolder) error {
+	file, err := z.fs.Writer(ctx, z.recordsFile())
+	if err != nil {
+		return fmt.Errorf("error opening writer for saving file: %w", err)
+	}
+
+	slices.SortFunc(records.Records, func(a, b SavedRecord) int {
+		return a.RecordedAt.Compare(b.RecordedAt)
+	})
+
+	records.UpdatedAt = time.Now()
+
+	if err := json.NewEncoder(file).Encode(records); err != nil {
+		return errors.Join(fmt.Errorf("error encoding file: %w", err), file.Abort())
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error closing file: %w", err)
+	}
+
+	return nil
+}
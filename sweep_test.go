@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSweep(t *testing.T) {
+	dir := "tmp_test_sweep"
+	c := SetupTest(t, dir)
+
+	c.config.RecordingTypes = []string{
+		string(RecordingTypeActiveSpeaker),
+		string(RecordingTypeGallery),
+		string(RecordingTypeGallery),
+		string(RecordingTypeSpeaker),
+	}
+	c.config.IgnoreTitles = []string{"ignore"}
+	c.config.StartingFromYear = 2022
+
+	if err := c.Sweep(); err != nil {
+		t.Fatalf("unexpected error during sweep: %v", err)
+	}
+
+	assertFileExists(t, path.Join(dir, "static/2022-10-01_00-00-00_gallery_view.mp4"))
+	assertFileExists(t, path.Join(dir, "static/2022-10-01_00-00-00_active_speaker.mp4"))
+	assertFileExists(t, path.Join(dir, "static/2022-11-01_00-00-00_active_speaker.mp4"))
+	assertFileExists(t, path.Join(dir, "static/2022-11-01_00-00-00_gallery_view.mp4"))
+	assertFileExists(t, path.Join(dir, "static2/2023-01-01_00-00-00_active_speaker.mp4"))
+	assertFileNotExists(t, path.Join(dir, "ignore/2023-01-02_00-00-00_.mp4"))
+
+	records, err := c.LoadRecords()
+	if err != nil {
+		t.Fatalf("unable to load records: %v", err)
+	}
+
+	for _, rec := range records.Records {
+		assert(t, rec.Size == 16, "record must contain the downloaded size")
+		assert(t, len(rec.SHA256) == 64, "record must contain the sha256 of the file")
+		assert(t, len(rec.Destinations) == 1 && rec.Destinations[0].OK, "record must contain the state of the destination")
+	}
+}
+
+func TestSweepAllUsers(t *testing.T) {
+	dir := "tmp_test_sweep_all_users"
+	c := SetupTest(t, dir)
+
+	c.config.RecordingTypes = []string{string(RecordingTypeGallery)}
+	c.config.IgnoreTitles = []string{"ignore"}
+	c.config.StartingFromYear = 2022
+	c.config.AllUsers = true
+	c.config.ExcludeUsers = []string{"Intern@example.com"}
+
+	if err := c.Sweep(); err != nil {
+		t.Fatalf("unexpected error during sweep: %v", err)
+	}
+
+	assertFileExists(t, path.Join(dir, "owner@example.com/static/2022-10-01_00-00-00_gallery_view.mp4"))
+	assertFileExists(t, path.Join(dir, "colleague@example.com/colleague/2022-10-02_00-00-00_gallery_view.mp4"))
+	assertFileNotExists(t, path.Join(dir, "intern@example.com"))
+	assertFileExists(t, path.Join(dir, "former@example.com/colleague/2022-10-02_00-00-00_gallery_view.mp4"))
+
+	rd, err := c.fs.Reader(context.Background(), SavedRecordFileName)
+	if err != nil {
+		t.Fatalf("unable to read savefile: %v", err)
+	}
+
+	records := &RecordHolder{}
+	if err := json.NewDecoder(rd).Decode(records); err != nil {
+		t.Fatalf("unable to decode savefile: %v", err)
+	}
+
+	for _, rec := range records.Records {
+		assert(t, rec.UserID != "" && rec.UserEmail != "", "saved record must contain the user")
+	}
+}
+
+func TestSweepDeleteAfter(t *testing.T) {
+	dir := "tmp_test_sweep_delete_after"
+	c, mock := SetupTestWithMock(t, dir)
+
+	c.config.RecordingTypes = []string{
+		string(RecordingTypeActiveSpeaker),
+		string(RecordingTypeGallery),
+	}
+	c.config.IgnoreTitles = []string{"ignore"}
+	c.config.StartingFromYear = 2022
+	c.config.DeleteAfter = true
+
+	for _, m := range mock.meetings {
+		if m.ID == 1002 {
+			mock.brokenFiles[m.RecordingFiles[0].ID] = true
+		}
+	}
+
+	_ = c.Sweep()
+
+	remaining := map[int]bool{}
+	for _, m := range mock.meetings {
+		remaining[m.ID] = true
+	}
+
+	assert(t, !remaining[1001], "archived meeting must be deleted")
+	assert(t, !remaining[1003], "archived meeting must be deleted")
+	assert(t, remaining[1002], "meeting with a failed download must not be deleted")
+	assert(t, remaining[1005], "ignored meeting must not be deleted")
+}
+
+func TestSweepDeleteRecurring(t *testing.T) {
+	dir := "tmp_test_sweep_delete_recurring"
+	c, mock := SetupTestWithMock(t, dir)
+
+	c.config.RecordingTypes = []string{string(RecordingTypeGallery)}
+	c.config.StartingFromYear = 2024
+	c.config.DeleteAfter = true
+	c.config.ExcludeRules = []string{"id=latest=="}
+
+	// the instances of a recurring meeting share the id, zoom resolves the
+	// id to the latest instance
+	for i, uuid := range []string{"archived==", "broken==", "latest=="} {
+		m := createMeeting(mock.baseURL, `recurring`, 4001, time.Date(2024, time.March, 1+7*i, 0, 0, 0, 0, time.UTC), RecordingTypeGallery)
+		m.UUID = uuid
+		m.HostID, m.HostEmail = mock.users[0].ID, mock.users[0].Email
+		mock.meetings = append(mock.meetings, m)
+
+		if uuid == "broken==" {
+			mock.brokenFiles[m.RecordingFiles[0].ID] = true
+		}
+	}
+
+	_ = c.Sweep()
+
+	remaining := map[string]bool{}
+	for _, m := range mock.meetings {
+		remaining[m.UUID] = true
+	}
+
+	assert(t, !remaining["archived=="], "archived instance must be deleted")
+	assert(t, remaining["broken=="], "instance with a failed download must not be deleted")
+	assert(t, remaining["latest=="], "excluded instance must not be deleted")
+	assert(t, len(mock.trash) == 1 && mock.trash[0].UUID == "archived==", "only the archived instance must be trashed")
+}
+
+func TestSweepDeleteFiles(t *testing.T) {
+	dir := "tmp_test_sweep_delete_files"
+	c, mock := SetupTestWithMock(t, dir)
+
+	c.config.RecordingTypes = []string{string(RecordingTypeGallery)}
+	c.config.IgnoreTitles = []string{"ignore"}
+	c.config.StartingFromYear = 2022
+	c.config.DeleteAfter = true
+	c.config.DeleteMode = "file"
+
+	if err := c.Sweep(); err != nil {
+		t.Fatalf("unexpected error during sweep: %v", err)
+	}
+
+	meeting, err := c.GetMeetingRecordings("1001")
+	if err != nil {
+		t.Fatalf("meeting must be kept in zoom: %v", err)
+	}
+
+	types := map[RecordingType]bool{}
+	for _, rf := range meeting.RecordingFiles {
+		types[rf.RecordingType] = true
+	}
+
+	assert(t, !types[RecordingTypeGallery], "archived recording file must be deleted")
+	assert(t, types[RecordingTypeActiveSpeaker], "recording file that isn't archived must be kept")
+	assert(t, types[RecordingTypeAudioOnly], "recording file that isn't archived must be kept")
+	assert(t, len(mock.trash) > 0, "archived recording files must be trashed")
+
+	for _, m := range mock.trash {
+		assert(t, len(m.RecordingFiles) == 1 && m.RecordingFiles[0].RecordingType == RecordingTypeGallery, "only the archived recording files must be trashed")
+	}
+}
+
+func TestSweepDeleteFilesRecurring(t *testing.T) {
+	dir := "tmp_test_sweep_delete_files_recurring"
+	c, mock := SetupTestWithMock(t, dir)
+
+	c.config.RecordingTypes = []string{string(RecordingTypeGallery)}
+	c.config.StartingFromYear = 2024
+	c.config.DeleteAfter = true
+	c.config.DeleteMode = "file"
+	c.config.ExcludeRules = []string{"id=latest=="}
+
+	for i, uuid := range []string{"archived==", "latest=="} {
+		m := createMeeting(mock.baseURL, `recurring`, 4001, time.Date(2024, time.March, 1+7*i, 0, 0, 0, 0, time.UTC), RecordingTypeGallery)
+		m.UUID = uuid
+		m.HostID, m.HostEmail = mock.users[0].ID, mock.users[0].Email
+		mock.meetings = append(mock.meetings, m)
+	}
+
+	if err := c.Sweep(); err != nil {
+		t.Fatalf("unexpected error during sweep: %v", err)
+	}
+
+	for _, m := range mock.meetings {
+		switch m.UUID {
+		case "archived==":
+			assert(t, len(m.RecordingFiles) == 0, "archived recording file must be deleted")
+		case "latest==":
+			assert(t, len(m.RecordingFiles) == 1, "recording file of the excluded instance must be kept")
+		}
+	}
+
+	assert(t, len(mock.trash) == 1 && mock.trash[0].UUID == "archived==", "only the archived recording file must be trashed")
+}
+
+func TestSweepGracePeriod(t *testing.T) {
+	dir := "tmp_test_sweep_grace_period"
+	c, mock := SetupTestWithMock(t, dir)
+
+	c.config.RecordingTypes = []string{string(RecordingTypeGallery)}
+	c.config.IgnoreTitles = []string{"ignore"}
+	c.config.StartingFromYear = 2022
+	c.config.DeleteAfter = true
+	c.config.DeleteAfterDays = 1
+
+	if err := c.Sweep(); err != nil {
+		t.Fatalf("unexpected error during sweep: %v", err)
+	}
+
+	if _, err := c.GetMeetingRecordings("1001"); err != nil {
+		t.Fatalf("meeting must not be deleted within the grace period: %v", err)
+	}
+
+	ctx := context.Background()
+	records, err := c.LoadRecords()
+	if err != nil {
+		t.Fatalf("unable to load records: %v", err)
+	}
+
+	for i := range records.Records {
+		records.Records[i].SavedAt = time.Now().Add(-48 * time.Hour)
+	}
+
+	if err := c.saveRecords(ctx, records); err != nil {
+		t.Fatalf("unable to save records: %v", err)
+	}
+
+	if err := c.Sweep(); err != nil {
+		t.Fatalf("unexpected error during sweep: %v", err)
+	}
+
+	trashed := map[int]bool{}
+	for _, m := range mock.trash {
+		trashed[m.ID] = true
+	}
+
+	assert(t, trashed[1001], "meeting must be moved to the trash after the grace period")
+
+	records, err = c.LoadRecords()
+	if err != nil {
+		t.Fatalf("unable to load records: %v", err)
+	}
+
+	for _, rec := range records.Records {
+		if rec.SessionID == "1001" {
+			assert(t, !rec.DeletedAt.IsZero(), "record of the deleted meeting must have a deletion time")
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	dir := "tmp_test_plan"
+	c := SetupTest(t, dir)
+
+	c.config.RecordingTypes = []string{string(RecordingTypeGallery)}
+	c.config.IgnoreTitles = []string{"ignore"}
+	c.config.StartingFromYear = 2022
+	c.config.DeleteAfter = true
+
+	plan, err := c.Plan()
+	if err != nil {
+		t.Fatalf("unexpected error planning sweep: %v", err)
+	}
+
+	targets := map[string]bool{}
+	for _, dl := range plan.Downloads {
+		targets[dl.Target] = true
+	}
+
+	assert(t, targets["static/2022-10-01_00-00-00_gallery_view.mp4"], "plan must download the allowed recording")
+	assert(t, !targets["ignore/2023-01-02_00-00-00_gallery_view.mp4"], "plan must not download ignored meetings")
+	assert(t, len(plan.Deletions) > 0, "plan must contain the deletions")
+
+	assertFileNotExists(t, dir)
+
+	if _, err := c.GetMeetingRecordings("1001"); err != nil {
+		t.Errorf("plan must not delete the recordings: %v", err)
+	}
+}
+
+func TestSaveRecords(t *testing.T) {
+	dir := "tmp_test_save"
+	c := SetupTest(t, dir)
+
+	ctx := context.Background()
+
+	if err := c.saveRecords(ctx, &RecordHolder{
+		Records: []SavedRecord{
+			{
+				ID:         "random_id2",
+				SessionID:  "random_session_id2",
+				SavedAt:    time.Now(),
+				RecordedAt: time.Date(2022, time.September, 9, 12, 34, 0, 0, time.Local),
+				Path:       "random/random2.mp4",
+			},
+			{
+				ID:         "random_id1",
+				SessionID:  "random_session_id1",
+				SavedAt:    time.Now(),
+				RecordedAt: time.Date(2022, time.January, 1, 12, 34, 0, 0, time.Local),
+				Path:       "random/random.mp4",
+			},
+			{
+				ID:         "random_id3",
+				SessionID:  "random_session_id3",
+				SavedAt:    time.Now(),
+				RecordedAt: time.Date(2022, time.December, 5, 12, 34, 0, 0, time.Local),
+				Path:       "random/random3.mp4",
+			},
+		},
+	}); err != nil {
+		t.Fatalf("unable to save records: %v", err)
+	}
+
+	rd, err := c.fs.Reader(ctx, SavedRecordFileName)
+	if err != nil {
+		t.Fatalf("unable to read savefile: %v", err)
+	}
+
+	records := &RecordHolder{}
+	err = json.NewDecoder(rd).Decode(records)
+	if err != nil {
+		t.Fatalf("unable to marshal json: %v", err)
+	}
+
+	if e, a := 3, len(records.Records); e != a {
+		t.Fatalf("expected %d but got %d", e, a)
+	}
+
+	if e, a := "random_id1", records.Records[0].ID; e != a {
+		t.Errorf("expected %s but got %s", e, a)
+	}
+
+	if e, a := "random_id2", records.Records[1].ID; e != a {
+		t.Errorf("expected %s but got %s", e, a)
+	}
+
+	if e, a := "random_id3", records.Records[2].ID; e != a {
+		t.Errorf("expected %s but got %s", e, a)
+	}
+}
+
+func assertFileExists(t *testing.T, fpath string) {
+	t.Helper()
+
+	_, err := os.Stat(fpath)
+	if err != nil {
+		t.Errorf("missing expected file %s", fpath)
+	}
+}
+
+func assertFileNotExists(t *testing.T, fpath string) {
+	t.Helper()
+
+	_, err := os.Stat(fpath)
+	if !strings.HasSuffix(err.Error(), "no such file or directory") {
+		t.Errorf("unexpected file %s, err: %v", fpath, err)
+	}
+}
+
+func TestLoadRecords(t *testing.T) {
+	dir := "tmp_test_load_records"
+	c := SetupTest(t, dir)
+
+	first, _ := newOsFS(path.Join(dir, "first"))   //nolint: errcheck
+	second, _ := newOsFS(path.Join(dir, "second")) //nolint: errcheck
+	third, _ := newOsFS(path.Join(dir, "third"))   //nolint: errcheck
+	c.fs = multifs{dsts: []destination{
+		{FileSystem: first, name: "first"},
+		{FileSystem: second, name: "second"},
+		{FileSystem: third, name: "third"},
+	}}
+
+	writeRecords := func(dst string, records RecordHolder) {
+		_ = os.MkdirAll(path.Join(dir, dst), os.ModePerm)                       //nolint: errcheck
+		data, _ := json.Marshal(records)                                        //nolint: errcheck
+		_ = os.WriteFile(path.Join(dir, dst, SavedRecordFileName), data, 0o600) //nolint: errcheck
+	}
+
+	deleted := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	writeRecords("second", RecordHolder{
+		UpdatedAt: time.Date(2024, time.January, 2, 0, 0, 0, 0, time.UTC),
+		Records:   []SavedRecord{{ID: "a", DeletedAt: deleted}, {ID: "b"}},
+	})
+	writeRecords("third", RecordHolder{
+		UpdatedAt: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+		Records:   []SavedRecord{{ID: "a"}, {ID: "c"}},
+	})
+
+	records, err := c.LoadRecords()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	saved := savedRecordMap(records)
+	assert(t, len(records.Records) == 3, "records of every destination must be merged")
+	assert(t, saved["a"].DeletedAt.Equal(deleted), "most recent records file must be used")
+
+	_ = os.WriteFile(path.Join(dir, "second", SavedRecordFileName), []byte("{invalid"), 0o600) //nolint: errcheck
+
+	records, err = c.LoadRecords()
+	assert(t, err == nil && len(records.Records) == 2, "invalid records file must be skipped")
+
+	_ = os.Remove(path.Join(dir, "third", SavedRecordFileName)) //nolint: errcheck
+	c.fs.(multifs).dsts[1].FileSystem = brokenfs{second}
+
+	_, err = c.LoadRecords()
+	assert(t, err != nil, "records must not be empty when the destination with the records file is unavailable")
+
+	writeRecords("first", RecordHolder{Records: []SavedRecord{{ID: "a"}}})
+
+	records, err = c.LoadRecords()
+	assert(t, err == nil && len(records.Records) == 1, "unavailable destination must not stop the sweep")
+
+	c.fs.(multifs).dsts[0].FileSystem = brokenfs{first}
+	c.fs.(multifs).dsts[2].FileSystem = brokenfs{third}
+
+	_, err = c.LoadRecords()
+	assert(t, err != nil, "records must not be empty when no destination is available")
+}
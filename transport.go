@@ -0,0 +1,273 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateCategory is the rate limit category of a zoom api call
+// https://developers.zoom.us/docs/api/rest/rate-limits/
+type RateCategory string
+
+const (
+	RateCategoryLight  RateCategory = "Light"
+	RateCategoryMedium RateCategory = "Medium"
+	RateCategoryHeavy  RateCategory = "Heavy"
+)
+
+// RetryPolicy defines how failed and rate limited requests are retried
+type RetryPolicy struct {
+	// MaxRetries is the amount of retries after the first attempt
+	MaxRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// RateLimits are the requests per second per category, 0 is unlimited
+	RateLimits map[RateCategory]int
+}
+
+// errRateLimited is returned when zoom asks to wait longer than the
+// maximum backoff, e.g. when the daily limit is reached
+var errRateLimited = errors.New("rate limited by zoom")
+
+// retryTransport retries rate limited requests and failed idempotent
+// requests with a jittered backoff and paces the api calls per rate limit
+// category
+type retryTransport struct {
+	base   http.RoundTripper
+	policy RetryPolicy
+	logger *log.Logger
+
+	mut     sync.Mutex
+	next    map[RateCategory]time.Time
+	blocked map[RateCategory]time.Time
+}
+
+func newRetryTransport(base http.RoundTripper, policy RetryPolicy, logger *log.Logger) *retryTransport {
+	return &retryTransport{
+		base:    base,
+		policy:  policy,
+		logger:  logger,
+		next:    map[RateCategory]time.Time{},
+		blocked: map[RateCategory]time.Time{},
+	}
+}
+
+// RoundTrip is an implementation of http.RoundTripper
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	category := rateCategory(req)
+
+	for attempt := 0; ; attempt++ {
+		if err := t.wait(req.Context(), category); err != nil {
+			return nil, err
+		}
+
+		res, err := t.base.RoundTrip(req)
+		if res != nil {
+			category = t.observe(category, res)
+		}
+
+		retry, wait := t.shouldRetry(req, res, err, attempt)
+		if !retry {
+			return res, err
+		}
+
+		if res != nil {
+			if res.StatusCode == http.StatusTooManyRequests {
+				t.block(category, time.Now().Add(wait))
+			}
+
+			io.Copy(io.Discard, res.Body) //nolint: errcheck
+			res.Body.Close()              //nolint: errcheck
+		}
+
+		if wait > t.policy.MaxBackoff {
+			return nil, fmt.Errorf("%w: %s %s can be retried in %s", errRateLimited, req.Method, req.URL.Path, wait.Round(time.Second))
+		}
+
+		if req.Body != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		t.logger.Printf("retrying %s %s in %s (attempt %d of %d)", req.Method, req.URL.Path, wait.Round(time.Millisecond), attempt+1, t.policy.MaxRetries)
+		if err := sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// shouldRetry returns if the request should be retried and how long to wait,
+// rate limited requests are always retried since zoom didn't handle them
+func (t *retryTransport) shouldRetry(req *http.Request, res *http.Response, err error, attempt int) (bool, time.Duration) {
+	if attempt >= t.policy.MaxRetries || req.Context().Err() != nil {
+		return false, 0
+	}
+
+	if req.Body != nil && req.GetBody == nil {
+		return false, 0
+	}
+
+	switch {
+	case err != nil:
+		return isIdempotent(req.Method), t.backoff(attempt)
+	case res.StatusCode == http.StatusTooManyRequests:
+		if wait, ok := retryAfter(res.Header, time.Now()); ok {
+			return true, wait
+		}
+
+		return true, t.backoff(attempt)
+	case res.StatusCode == http.StatusBadGateway,
+		res.StatusCode == http.StatusServiceUnavailable,
+		res.StatusCode == http.StatusGatewayTimeout,
+		res.StatusCode == http.StatusInternalServerError:
+		return isIdempotent(req.Method), t.backoff(attempt)
+	default:
+		return false, 0
+	}
+}
+
+// backoff returns the exponential backoff of the attempt with jitter
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	d := t.policy.MinBackoff << min(attempt, 30)
+	if d <= 0 || d > t.policy.MaxBackoff {
+		d = t.policy.MaxBackoff
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// wait blocks until a request of the category is allowed
+func (t *retryTransport) wait(ctx context.Context, category RateCategory) error {
+	t.mut.Lock()
+	now := time.Now()
+	until := t.blocked[category]
+	if until.Sub(now) > t.policy.MaxBackoff {
+		t.mut.Unlock()
+		return fmt.Errorf("%w: %s calls are blocked until %s", errRateLimited, category, until.Format(time.DateTime))
+	}
+
+	if next := t.next[category]; next.After(until) {
+		until = next
+	}
+
+	if limit := t.policy.RateLimits[category]; limit > 0 {
+		t.next[category] = latest(until, now).Add(time.Second / time.Duration(limit))
+	}
+	t.mut.Unlock()
+
+	return sleep(ctx, time.Until(until))
+}
+
+// sleep waits for the duration or until the context is done
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// observe returns the category zoom reports for the response and blocks the
+// category when its daily limit is used up
+func (t *retryTransport) observe(category RateCategory, res *http.Response) RateCategory {
+	category = cmp.Or(RateCategory(res.Header.Get("X-RateLimit-Category")), category)
+
+	if isDailyLimitReached(res.Header) {
+		t.block(category, dailyReset(time.Now()))
+	}
+
+	return category
+}
+
+// block holds the requests of the category until the given time
+func (t *retryTransport) block(category RateCategory, until time.Time) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	t.blocked[category] = latest(t.blocked[category], until)
+}
+
+// rateCategory returns the rate limit category of the zoom api calls used
+// by zoomdl, other requests (e.g. the downloads) aren't paced
+func rateCategory(req *http.Request) RateCategory {
+	path := strings.TrimPrefix(req.URL.Path, "/v2")
+	elems := strings.Split(strings.Trim(path, "/"), "/")
+
+	switch {
+	case elems[0] == "report":
+		return RateCategoryHeavy
+	case elems[0] == "users" && len(elems) == 1,
+		elems[0] == "users" && len(elems) == 3 && elems[2] == "recordings":
+		return RateCategoryMedium
+	case elems[0] == "users", elems[0] == "meetings":
+		return RateCategoryLight
+	default:
+		return ""
+	}
+}
+
+// isIdempotent returns if the method can be retried safely
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// isDailyLimitReached returns if the rate limit headers report that the daily
+// limit is used up
+func isDailyLimitReached(header http.Header) bool {
+	return strings.EqualFold(header.Get("X-RateLimit-Type"), "Daily-limit") &&
+		header.Get("X-RateLimit-Remaining") == "0"
+}
+
+// dailyReset returns the time the daily limits are reset, at 00:00 UTC
+func dailyReset(now time.Time) time.Time {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+// retryAfter returns the wait time of the Retry-After header (seconds or a
+// date) or until the daily reset when the daily limit is used up
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	if v := header.Get("Retry-After"); v != "" {
+		if seconds, err := strconv.Atoi(v); err == nil {
+			return time.Duration(max(seconds, 0)) * time.Second, true
+		}
+
+		if t, err := http.ParseTime(v); err == nil {
+			return max(t.Sub(now), 0), true
+		}
+
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return max(t.Sub(now), 0), true
+		}
+	}
+
+	if isDailyLimitReached(header) {
+		return dailyReset(now).Sub(now), true
+	}
+
+	return 0, false
+}
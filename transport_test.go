@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testRetryPolicy = RetryPolicy{
+	MaxRetries: 3,
+	MinBackoff: time.Millisecond,
+	MaxBackoff: 50 * time.Millisecond,
+}
+
+func TestRetryRateLimited(t *testing.T) {
+	c, mock := SetupTestWithMock(t, "tmp_test_retry_rate_limited")
+
+	c.config.StartingFromYear = 2017
+	c.config.Retry = testRetryPolicy
+	c = NewZoomClient(c.config, c.fs)
+
+	mock.RateLimit(3, http.Header{
+		"Retry-After":          {"0"},
+		"X-RateLimit-Category": {"Medium"},
+		"X-RateLimit-Type":     {"QPS"},
+	})
+
+	meetings, err := c.ListAllRecordings(time.Time{})
+	if err != nil {
+		t.Fatalf("rate limited calls must be retried: %v", err)
+	}
+
+	assert(t, len(meetings) == 15, "expect 15 recordings")
+}
+
+func TestRetryDailyLimit(t *testing.T) {
+	c, mock := SetupTestWithMock(t, "tmp_test_retry_daily_limit")
+
+	c.config.Retry = testRetryPolicy
+	c = NewZoomClient(c.config, c.fs)
+
+	mock.RateLimit(1, http.Header{
+		"X-RateLimit-Category":  {"Light"},
+		"X-RateLimit-Type":      {"Daily-limit"},
+		"X-RateLimit-Remaining": {"0"},
+	})
+
+	_, err := c.GetMeetingRecordings("1001")
+	assert(t, errors.Is(err, errRateLimited), "daily limit must not be waited for")
+
+	requests := mock.requests.Load()
+	_, err = c.GetMeetingRecordings("1002")
+	assert(t, errors.Is(err, errRateLimited), "calls of the category must fail until the daily reset")
+	assert(t, mock.requests.Load() == requests, "calls of the category must not be sent until the daily reset")
+
+	_, err = c.ListUsers()
+	assert(t, err == nil, "calls of other categories must continue")
+}
+
+func TestRateLimitedListing(t *testing.T) {
+	c, mock := SetupTestWithMock(t, "tmp_test_rate_limited_listing")
+
+	c.config.StartingFromYear = 2017
+
+	mock.RateLimit(1, nil)
+
+	_, err := c.ListAllRecordings(time.Time{})
+	assert(t, err != nil, "rate limited listing must return an error")
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.ListAllRecordings(time.Time{})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		assert(t, err == nil, "listing after a failed listing must succeed")
+	case <-time.After(5 * time.Second):
+		t.Fatal("listing after a failed listing must not block")
+	}
+}
+
+func TestRetryIdempotent(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		wr.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+
+	cli := &http.Client{Transport: newRetryTransport(http.DefaultTransport, testRetryPolicy, log.Default())}
+
+	res, err := cli.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res.Body.Close() //nolint: errcheck
+
+	assert(t, res.StatusCode == http.StatusServiceUnavailable, "last response must be returned")
+	assert(t, requests.Load() == 4, "idempotent call must be retried")
+
+	requests.Store(0)
+	res, err = cli.Post(server.URL, "text/plain", strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res.Body.Close() //nolint: errcheck
+
+	assert(t, requests.Load() == 1, "non idempotent call must not be retried")
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, time.May, 1, 22, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		wait   time.Duration
+		ok     bool
+	}{
+		{"seconds", http.Header{"Retry-After": {"5"}}, 5 * time.Second, true},
+		{"http date", http.Header{"Retry-After": {"Wed, 01 May 2024 22:00:30 GMT"}}, 30 * time.Second, true},
+		{"rfc3339", http.Header{"Retry-After": {"2024-05-01T22:01:00Z"}}, time.Minute, true},
+		{"daily limit", rateLimitHeader("Daily-limit", "0"), 2 * time.Hour, true},
+		{"qps", rateLimitHeader("QPS", "0"), 0, false},
+		{"none", http.Header{}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wait, ok := retryAfter(tt.header, now)
+			if wait != tt.wait || ok != tt.ok {
+				t.Errorf("expected %s %v but got %s %v", tt.wait, tt.ok, wait, ok)
+			}
+		})
+	}
+}
+
+func rateLimitHeader(limitType, remaining string) http.Header {
+	header := http.Header{}
+	header.Set("X-RateLimit-Type", limitType)
+	header.Set("X-RateLimit-Remaining", remaining)
+
+	return header
+}
+
+func TestRateCategory(t *testing.T) {
+	tests := map[string]RateCategory{
+		"GET /v2/users":                        RateCategoryMedium,
+		"GET /v2/users/me":                     RateCategoryLight,
+		"GET /v2/users/me/recordings":          RateCategoryMedium,
+		"GET /v2/meetings/abc/recordings":      RateCategoryLight,
+		"DELETE /v2/meetings/1/recordings/abc": RateCategoryLight,
+		"GET /v2/report/meetings/1":            RateCategoryHeavy,
+		"GET /rec/download/abc":                "",
+	}
+
+	for call, expected := range tests {
+		method, path, _ := strings.Cut(call, " ")
+		req := httptest.NewRequest(method, path, nil)
+
+		if actual := rateCategory(req); actual != expected {
+			t.Errorf("%s: expected '%s' but got '%s'", call, expected, actual)
+		}
+	}
+}
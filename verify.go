@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// VerifyReport is the result of checking the saved records of an account
+// against its destinations
+type VerifyReport struct {
+	Account      string              `json:"account,omitempty"`
+	Destinations []DestinationReport `json:"destinations"`
+}
+
+// DestinationReport contains the files of a destination that don't match
+// the saved records
+type DestinationReport struct {
+	Destination string          `json:"destination"`
+	Checked     int             `json:"checked"`
+	Missing     []string        `json:"missing"`
+	Corrupted   []CorruptedFile `json:"corrupted"`
+	Orphaned    []string        `json:"orphaned"`
+}
+
+// CorruptedFile is a file that differs from its saved record
+type CorruptedFile struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// OK returns if every destination matches the saved records
+func (r *VerifyReport) OK() bool {
+	for _, dst := range r.Destinations {
+		if len(dst.Missing) > 0 || len(dst.Corrupted) > 0 || len(dst.Orphaned) > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Verify checks if every saved record exists in every destination of the
+// record (the default destinations or those of its route) with the saved
+// size and (unless quick) the saved hash, files in the destinations that
+// aren't in the saved records are reported as orphaned. The files of the
+// other accounts in the destinations they share aren't orphaned
+func (z *ZoomClient) Verify(quick bool, others ...*ZoomClient) (report *VerifyReport, err error) {
+	shared, errs := z.sharedFiles(others)
+
+	ctx, done := z.begin()
+	defer done()
+
+	state, err := z.openState(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer z.closeState(state, &err)
+
+	records, err := state.Records(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	partials, err := state.Partials(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	known := knownFiles(z.recordsFile(), records, partials)
+
+	report = &VerifyReport{Account: z.config.Name}
+
+	// the destinations the file of every record should be in
+	recordDsts := make([][]destination, len(records))
+	for i, rec := range records {
+		recordDsts[i] = z.recordDestinations(rec)
+	}
+
+	for _, dst := range z.allDestinations() {
+		dr := DestinationReport{
+			Destination: dst.name,
+			Missing:     []string{},
+			Corrupted:   []CorruptedFile{},
+			Orphaned:    []string{},
+		}
+
+		for i, rec := range records {
+			if !slices.ContainsFunc(recordDsts[i], func(d destination) bool { return d.name == dst.name }) {
+				continue
+			}
+
+			dr.Checked++
+
+			reason, err := z.verifyRecord(dst, rec, quick)
+			switch {
+			case errors.Is(err, fs.ErrNotExist):
+				dr.Missing = append(dr.Missing, rec.Path)
+			case err != nil:
+				errs = errors.Join(errs, fmt.Errorf("%s: %w", dst.name, err))
+			case reason != "":
+				dr.Corrupted = append(dr.Corrupted, CorruptedFile{Path: rec.Path, Reason: reason})
+			}
+		}
+
+		files, err := dst.List(ctx, "")
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("%s: %w", dst.name, err))
+		}
+
+		for _, file := range files {
+			if !known[file.Name] && !shared[dst.name][file.Name] {
+				dr.Orphaned = append(dr.Orphaned, file.Name)
+			}
+		}
+
+		report.Destinations = append(report.Destinations, dr)
+	}
+
+	return report, errs
+}
+
+// knownFiles returns the files of an account in its destinations: the saved
+// records file, the recording files and the partial downloads
+func knownFiles(recordsFile string, records []SavedRecord, partials []PartialDownload) map[string]bool {
+	known := map[string]bool{recordsFile: true}
+	for _, rec := range records {
+		known[rec.Path] = true
+	}
+
+	for _, partial := range partials {
+		known[partial.Path+partialSuffix] = true
+	}
+
+	return known
+}
+
+// sharedFiles returns the files of the other accounts by the destinations
+// they share with the account, e.g. when the accounts use the global
+// destinations
+func (z *ZoomClient) sharedFiles(others []*ZoomClient) (map[string]map[string]bool, error) {
+	names := map[string]bool{}
+	for _, dst := range z.allDestinations() {
+		names[dst.name] = true
+	}
+
+	shared := map[string]map[string]bool{}
+
+	var errs error
+	for _, other := range others {
+		dsts := []string{}
+		for _, dst := range other.allDestinations() {
+			if names[dst.name] {
+				dsts = append(dsts, dst.name)
+			}
+		}
+
+		if len(dsts) == 0 {
+			continue
+		}
+
+		known, err := other.knownFiles()
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("account %s: %w", other.config.Name, err))
+			continue
+		}
+
+		for _, name := range dsts {
+			if shared[name] == nil {
+				shared[name] = map[string]bool{}
+			}
+
+			maps.Copy(shared[name], known)
+		}
+	}
+
+	return shared, errs
+}
+
+// knownFiles returns the files of the account in its destinations
+func (z *ZoomClient) knownFiles() (known map[string]bool, err error) {
+	ctx, done := z.begin()
+	defer done()
+
+	state, err := z.openState(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer z.closeState(state, &err)
+
+	records, err := state.Records(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	partials, err := state.Partials(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return knownFiles(z.recordsFile(), records, partials), nil
+}
+
+// verifyRecord returns the reason why the file of the record is corrupted
+// in the destination
+func (z *ZoomClient) verifyRecord(dst destination, rec SavedRecord, quick bool) (string, error) {
+	info, err := dst.Stat(z.context, rec.Path)
+	if err != nil {
+		return "", err
+	}
+
+	if rec.Size > 0 && info.Size != rec.Size {
+		return fmt.Sprintf("size %d but %d bytes were downloaded", info.Size, rec.Size), nil
+	}
+
+	if quick || rec.SHA256 == "" {
+		return "", nil
+	}
+
+	rd, err := dst.Reader(z.context, rec.Path)
+	if err != nil {
+		return "", err
+	}
+
+	if closer, ok := rd.(io.Closer); ok {
+		defer closer.Close() //nolint: errcheck
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, rd); err != nil {
+		return "", err
+	}
+
+	if sum := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(sum, rec.SHA256) {
+		return fmt.Sprintf("sha256 %s but %s was downloaded", sum, rec.SHA256), nil
+	}
+
+	return "", nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/base64"
 	"encoding/json"
@@ -11,17 +12,12 @@ import (
 	"log"
 	"net/http"
 	"net/url"
-	"path"
-	"slices"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/jobstoit/httpio"
 )
 
-const API_CALL_CONCURRENCY_LIMIT = 2
-
 const (
 	RecordingTypeScharedScreenWithSpeakerCC RecordingType = "shared_screen_with_speaker_view(CC)"
 	RecordingTypeScharedScreenWithSpeaker   RecordingType = "shared_screen_with_speaker_view"
@@ -50,10 +46,27 @@ type ListAllRecordsResponse struct {
 	Meetings      []Meeting `json:"meetings"`
 }
 
+// ListUsersResponse is the response of the users list endpoint
+type ListUsersResponse struct {
+	NextPageToken string `json:"next_page_token"`
+	Users         []User `json:"users"`
+}
+
+// User contains the user details
+type User struct {
+	ID        string `json:"id"`
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Status    string `json:"status"`
+}
+
 // Meeting contains the meeting details
 type Meeting struct {
 	ID             int             `json:"id"`
 	UUID           string          `json:"uuid"`
+	HostID         string          `json:"host_id"`
+	HostEmail      string          `json:"host_email"`
 	Topic          string          `json:"topic"`
 	RecordingFiles []RecordingFile `json:"recording_files"`
 	StartTime      time.Time       `json:"-"`
@@ -65,6 +78,7 @@ type RecordingFile struct {
 	RecordingType  RecordingType `json:"recording_type"`
 	RecordingStart time.Time     `json:"recording_start"`
 	FileExtension  string        `json:"file_extension"`
+	FileSize       int64         `json:"file_size"`
 	DownloadURL    string        `json:"download_url"`
 }
 
@@ -78,6 +92,8 @@ type ZoomClient struct {
 	mut     chan bool
 	context context.Context
 	fs      FileSystem
+	routes  []route
+	logger  *log.Logger
 }
 
 func (z *ZoomClient) lock() {
@@ -98,18 +114,27 @@ type AccessToken struct {
 
 type ZoomClientOption func(*ZoomClient) error
 
-// NewZoomClient creates a new instance of the zoom client
-func NewZoomClient(cfg *Config, fs FileSystem) *ZoomClient {
+// NewZoomClient creates a new instance of the zoom client, the recording
+// files are written to fs unless a route matches
+func NewZoomClient(cfg *Config, fs FileSystem, routes ...route) *ZoomClient {
 	z := &ZoomClient{}
 	z.config = cfg
-	z.cli = &http.Client{
-		Transport: &http.Transport{
-			MaxIdleConnsPerHost: 100000,
-		},
-	}
 	z.BaseURL = z.config.APIEndpoint
 	z.mut = make(chan bool, cfg.Concurrency)
 	z.fs = fs
+	z.routes = routes
+	z.context = context.Background()
+
+	z.logger = log.Default()
+	if cfg.Name != "" {
+		z.logger = log.New(log.Writer(), fmt.Sprintf("[%s] ", cfg.Name), log.Flags())
+	}
+
+	z.cli = &http.Client{
+		Transport: newRetryTransport(&http.Transport{
+			MaxIdleConnsPerHost: 100000,
+		}, cfg.Retry, z.logger),
+	}
 
 	return z
 }
@@ -131,7 +156,7 @@ func (z *ZoomClient) Authorize() (*AccessToken, error) {
 		return nil, err
 	}
 
-	bearer := base64.StdEncoding.EncodeToString(fmt.Appendf([]byte{}, "%s:%s", z.config.ClientID, z.config.ClientSecret))
+	bearer := base64.StdEncoding.EncodeToString(fmt.Appendf([]byte{}, "%s:%s", z.config.ClientID, string(z.config.ClientSecret)))
 	req.Header.Add(`Authorization`, fmt.Sprintf("Basic %s", bearer))
 	req.Header.Add(`Content-Type`, "application/x-www-form-urlencoded")
 
@@ -158,8 +183,82 @@ func (z *ZoomClient) Authorize() (*AccessToken, error) {
 	return a, nil
 }
 
-// ListAllRecordings returns all recordings
+// userStatuses are the statuses of the users whose recordings are swept,
+// the recordings of deactivated users are kept until they're deleted
+var userStatuses = []string{"active", "inactive"}
+
+// ListUsers returns all the active and deactivated users in the account
+func (z *ZoomClient) ListUsers() ([]User, error) {
+	users := []User{}
+	for _, status := range userStatuses {
+		listed, err := z.listUsers(status)
+		users = append(users, listed...)
+		if err != nil {
+			return users, err
+		}
+	}
+
+	return users, nil
+}
+
+// listUsers returns the users in the account with the status
+func (z *ZoomClient) listUsers(status string) ([]User, error) {
+	endpoint := z.BaseURL.JoinPath("users")
+	query := endpoint.Query()
+	query.Set("page_size", "300")
+	query.Set("status", status)
+
+	users := []User{}
+	for {
+		endpoint.RawQuery = query.Encode()
+
+		res, err := z.do(http.MethodGet, endpoint.String(), nil)
+		if err != nil {
+			return users, err
+		}
+
+		r := ListUsersResponse{}
+		err = json.NewDecoder(res.Body).Decode(&r)
+		res.Body.Close() //nolint: errcheck
+		if err != nil {
+			return users, err
+		}
+
+		users = append(users, r.Users...)
+		if r.NextPageToken == "" {
+			break
+		}
+
+		query.Set("next_page_token", r.NextPageToken)
+	}
+
+	return users, nil
+}
+
+// GetUser returns the user with the given id or email
+func (z *ZoomClient) GetUser(userID string) (User, error) {
+	u := User{}
+
+	res, err := z.do(http.MethodGet, z.BaseURL.JoinPath("users", userID).String(), nil)
+	if err != nil {
+		return u, err
+	}
+	defer res.Body.Close() //nolint: errcheck
+
+	if err := json.NewDecoder(res.Body).Decode(&u); err != nil {
+		return u, err
+	}
+
+	return u, nil
+}
+
+// ListAllRecordings returns all recordings of the authorized user
 func (z *ZoomClient) ListAllRecordings(from time.Time) ([]Meeting, error) {
+	return z.ListUserRecordings("me", from)
+}
+
+// ListUserRecordings returns all recordings of the given user
+func (z *ZoomClient) ListUserRecordings(userID string, from time.Time) ([]Meeting, error) {
 	if z.token == nil || time.Now().After(z.token.ExpiresAt) {
 		at, err := z.Authorize()
 		if err != nil {
@@ -168,29 +267,31 @@ func (z *ZoomClient) ListAllRecordings(from time.Time) ([]Meeting, error) {
 		z.token = at
 	}
 
-	concurrency := min(z.config.Concurrency, API_CALL_CONCURRENCY_LIMIT)
-
-	ch := make(chan meetingsChan, concurrency)
-	count := 0
-
-	endpointURL := z.BaseURL.JoinPath("users/me/recordings")
+	endpointURL := z.BaseURL.JoinPath("users", userID, "recordings")
 	if from.IsZero() {
 		from = time.Date(z.config.StartingFromYear, 1, 1, 0, 0, 0, 0, time.Local)
 	}
 
-	now := time.Now()
+	months := []time.Time{}
+	for d := time.Now(); !d.Before(from); d = d.AddDate(0, -1, 0) {
+		months = append(months, d)
+	}
 
-	for d := now; !d.Before(from); d = d.AddDate(0, -1, 0) {
+	// every month has room in the channel so a failed month doesn't block
+	// the others from releasing the lock
+	ch := make(chan meetingsChan, len(months))
+	for _, d := range months {
 		go z.getMeetings(ch, endpointURL, d)
-		count++
 	}
 
 	meetings := []Meeting{}
 
-	for range count {
+	var err error
+	for range months {
 		res := <-ch
 		if res.err != nil {
-			return meetings, res.err
+			err = cmp.Or(err, res.err)
+			continue
 		}
 
 		meetings = append(meetings, res.meetings...)
@@ -198,6 +299,10 @@ func (z *ZoomClient) ListAllRecordings(from time.Time) ([]Meeting, error) {
 
 	close(ch)
 
+	if err != nil {
+		return meetings, err
+	}
+
 	meetings = clearDuplicateMeetings(meetings)
 
 	return meetings, nil
@@ -215,22 +320,21 @@ type meetingsChan struct {
 
 func (z *ZoomClient) getMeetings(ch chan meetingsChan, endpoint *url.URL, from time.Time) {
 	z.lock()
-	defer z.unlock()
 
 	res := meetingsChan{}
-	query := endpoint.Query()
+	query := url.Values{}
 	query.Set("page_size", "300")
 	query.Set("from", dateFormat(from.AddDate(0, -1, 0)))
 	query.Set("to", dateFormat(from))
 
 	for {
-		m, err := z.getMeeting(endpoint, query)
+		m, err := z.getMeeting(*endpoint, query)
 		if err != nil {
 			res.err = err
 			break
 		}
 
-		res.meetings = m.Meetings
+		res.meetings = append(res.meetings, m.Meetings...)
 		if m.NextPageToken == "" {
 			break
 		}
@@ -238,13 +342,14 @@ func (z *ZoomClient) getMeetings(ch chan meetingsChan, endpoint *url.URL, from t
 		query.Set("next_page_token", m.NextPageToken)
 	}
 
-	query.Del("next_page_token")
-	endpoint.RawQuery = query.Encode()
+	z.unlock()
 
 	ch <- res
 }
 
-func (z *ZoomClient) getMeeting(endpoint *url.URL, queries url.Values) (ListAllRecordsResponse, error) {
+// getMeeting requests a single page of recordings, the endpoint is passed
+// by value since the months are requested concurrently
+func (z *ZoomClient) getMeeting(endpoint url.URL, queries url.Values) (ListAllRecordsResponse, error) {
 	r := ListAllRecordsResponse{}
 	endpoint.RawQuery = queries.Encode()
 
@@ -277,11 +382,58 @@ func clearDuplicateMeetings(ms []Meeting) []Meeting {
 	return ms
 }
 
-// DeleteRecording deletes the recording on zoom
-func (z *ZoomClient) DeleteRecording(id int) error {
-	url := z.BaseURL.JoinPath("meetings", strconv.Itoa(id), "recordings").String()
+// GetMeetingRecordings returns the meeting with its recording files
+func (z *ZoomClient) GetMeetingRecordings(meetingUUID string) (Meeting, error) {
+	m := Meeting{}
+
+	res, err := z.do(http.MethodGet, meetingEndpoint(z.BaseURL, meetingUUID, "recordings").String(), nil)
+	if err != nil {
+		return m, err
+	}
+	defer res.Body.Close() //nolint: errcheck
+
+	if err := json.NewDecoder(res.Body).Decode(&m); err != nil {
+		return m, err
+	}
+
+	return m, nil
+}
+
+// meetingEndpoint returns the endpoint of the meeting, a meeting UUID that
+// starts with a '/' or contains '//' must be double encoded
+func meetingEndpoint(baseURL *url.URL, meetingUUID string, elem ...string) *url.URL {
+	escaped := url.PathEscape(meetingUUID)
+	if strings.HasPrefix(meetingUUID, "/") || strings.Contains(meetingUUID, "//") {
+		escaped = url.PathEscape(escaped)
+	}
+
+	return baseURL.JoinPath("meetings", escaped).JoinPath(elem...)
+}
+
+// DeleteRecording deletes the recordings of the meeting on zoom, depending
+// on the configured delete action they're moved to the trash or deleted
+// permanently. The meeting is addressed by its UUID since zoom resolves
+// the id of a recurring meeting to its latest instance
+func (z *ZoomClient) DeleteRecording(meetingUUID string) error {
+	endpoint := meetingEndpoint(z.BaseURL, meetingUUID, "recordings")
+	endpoint.RawQuery = url.Values{"action": {cmp.Or(z.config.DeleteAction, "trash")}}.Encode()
+
+	res, err := z.do(http.MethodDelete, endpoint.String(), &bytes.Buffer{})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close() //nolint: errcheck
+
+	return nil
+}
+
+// DeleteRecordingFile deletes a single recording file of the meeting on zoom,
+// the other recording files of the meeting are kept
+func (z *ZoomClient) DeleteRecordingFile(meetingUUID, fileID string) error {
+	endpoint := meetingEndpoint(z.BaseURL, meetingUUID, "recordings", fileID)
+	endpoint.RawQuery = url.Values{"action": {cmp.Or(z.config.DeleteAction, "trash")}}.Encode()
 
-	res, err := z.do(http.MethodDelete, url, &bytes.Buffer{})
+	res, err := z.do(http.MethodDelete, endpoint.String(), &bytes.Buffer{})
 	if err != nil {
 		return err
 	}
@@ -290,159 +442,193 @@ func (z *ZoomClient) DeleteRecording(id int) error {
 	return nil
 }
 
-// DownloadVideo downloads the video to the given file and returns the path
-func (z *ZoomClient) DownloadVideo(sessionTitle string, rec RecordingFile) (string, error) {
-	recordingTime := rec.RecordingStart
+// downloaded is a recording file that is written to the destinations
+type downloaded struct {
+	sum    string
+	size   int64
+	failed map[string]error // destinations that were dropped from the write
+}
 
-	sessionTitle = serializPathString(sessionTitle)
-	fileExtention := strings.ToLower(rec.FileExtension)
+// download downloads the recording file to the target in the file system and
+// returns the sha256 and size of the file, the download fails when the size
+// differs from the size zoom reports
+func (z *ZoomClient) download(fs FileSystem, target string, rec RecordingFile, partial *PartialDownload) (downloaded, error) {
+	if resumer, ok := fs.(Resumer); ok {
+		return z.resumeDownload(resumer, target, rec, partial)
+	}
 
-	target := path.Join(sessionTitle, fmt.Sprintf("%04d-%02d-%02d_%02d-%02d-%02d_%s.%s",
-		rec.RecordingStart.Year(),
-		int(recordingTime.Month()),
-		recordingTime.Day(),
-		recordingTime.Hour(),
-		recordingTime.Minute(),
-		recordingTime.Second(),
-		string(rec.RecordingType),
-		fileExtention,
-	))
+	file, err := fs.Writer(z.context, target)
+	if err != nil {
+		return downloaded{}, err
+	}
 
-	file, err := z.fs.Writer(z.context, target)
+	remoteFile, err := z.get(rec)
 	if err != nil {
-		return "", err
+		return downloaded{}, errors.Join(err, file.Abort())
 	}
-	defer file.Close() // nolint: errcheck
 
-	if z.token == nil || time.Now().After(z.token.ExpiresAt) {
-		at, err := z.Authorize()
-		if err != nil {
-			return "", err
-		}
-		z.token = at
+	hw := newHashWriter(file)
+	if _, err := io.Copy(hw, remoteFile); err != nil {
+		z.logger.Printf("error writing data: %v", err)
+		return downloaded{}, errors.Join(err, file.Abort())
 	}
 
-	remoteFile, err := httpio.Get(
-		rec.DownloadURL,
-		httpio.WithClient(z.cli),
-		httpio.WithChunkSize(1024*1024*z.config.ChunckSizeMB),
-		httpio.WithConcurrency(z.config.Concurrency),
-		httpio.WithHeader("Authorization", fmt.Sprintf("Bearer %s", z.token.AccessToken)),
-	)
-	if err != nil {
-		log.Printf("error fetching data: %v", err)
-		return "", err
+	if err := checkSize(rec, hw.size); err != nil {
+		return downloaded{}, errors.Join(err, file.Abort())
 	}
 
-	if _, err := io.Copy(file, remoteFile); err != nil {
-		log.Printf("error writing data: %v", err)
-		return "", err
+	if err := file.Close(); err != nil {
+		return downloaded{}, err
 	}
 
-	return target, err
+	return downloaded{sum: hw.Sum(), size: hw.size, failed: failedDestinations(file)}, nil
 }
 
-// RecordHolder holds stores the saved records
-type RecordHolder struct {
-	Records []SavedRecord
-}
+// resumeDownload downloads the recording file continuing from the offset of
+// the partial download when the remote file is unchanged, on failures the
+// written data is kept and the partial download is updated
+func (z *ZoomClient) resumeDownload(resumer Resumer, target string, rec RecordingFile, partial *PartialDownload) (downloaded, error) {
+	if partial.Size != rec.FileSize {
+		*partial = PartialDownload{ID: rec.ID, Path: target, Size: rec.FileSize}
+	}
 
-// Sweep will get all the records and download the specified files
-func (z *ZoomClient) Sweep() error {
-	ctx, cancel := context.WithCancel(context.Background())
-	z.context = ctx
-	defer cancel()
+	rw, err := resumer.Resume(z.context, target, partial.State)
+	if err != nil {
+		return downloaded{}, err
+	}
 
-	saveFile, err := z.fs.Reader(z.context, SavedRecordFileName)
+	file, err := newHashingWriter(rw, partial.Offset, partial.HashState)
 	if err != nil {
-		return err
+		rw.Pause() //nolint: errcheck
+		return downloaded{}, err
 	}
 
-	records := &RecordHolder{}
-	if err := json.NewDecoder(saveFile).Decode(records); err != nil && err != io.EOF {
-		log.Printf("unable to read record file: %v", err)
+	offset := file.Offset()
+	if offset > 0 {
+		z.logger.Printf("resuming %s from byte %d", target, offset)
 	}
 
-	defer z.saveRecords(ctx, records)
+	remoteFile, start, err := z.getRange(rec, offset, partial)
+	if err == nil && start != offset { // the remote file changed
+		_, err = file.Rewind(start)
+	}
 
-	var from time.Time
-	if len(records.Records) > 0 {
-		from = records.Records[len(records.Records)-1].RecordedAt
+	if err == nil {
+		_, err = io.Copy(file, remoteFile)
+		remoteFile.Close() //nolint: errcheck
 	}
 
-	log.Print(`pulling recordings`)
-	meetings, err := z.ListAllRecordings(from)
 	if err != nil {
-		return err
+		z.logger.Printf("error writing data: %v", err)
+		partial.Offset = file.Offset()
+		partial.State = file.State()
+		partial.HashState = file.HashState()
+
+		return downloaded{}, errors.Join(err, file.Pause())
+	}
+
+	if err := checkSize(rec, file.Size()); err != nil {
+		return downloaded{}, errors.Join(err, file.Abort())
+	}
+
+	if err := file.Close(); err != nil {
+		return downloaded{}, err
+	}
+
+	return downloaded{sum: file.Sum(), size: file.Size(), failed: failedDestinations(rw)}, nil
+}
+
+// failedDestinations returns the destinations the writer dropped
+func failedDestinations(w any) map[string]error {
+	if pw, ok := w.(PartialWriter); ok {
+		return pw.Failed()
 	}
 
-	log.Printf("fetched %d entries", len(meetings))
-	recordIDs := getRecordMap(records.Records)
-	allowedTypes := strings.Join(z.config.RecordingTypes, " ")
-	ignoredTitles := strings.Join(z.config.IgnoreTitles, " ")
+	return nil
+}
 
-	var errs error
-	for _, meeting := range meetings {
-		if ignoredTitles != "" && strings.Contains(ignoredTitles, meeting.Topic) {
-			goto CLEANUP
+// get starts the download of the recording file, concurrently in chunks
+func (z *ZoomClient) get(rec RecordingFile) (io.Reader, error) {
+	if z.token == nil || time.Now().After(z.token.ExpiresAt) {
+		at, err := z.Authorize()
+		if err != nil {
+			return nil, err
 		}
+		z.token = at
+	}
 
-		for _, rf := range meeting.RecordingFiles {
-			if rf.FileExtension == "" ||
-				string(rf.RecordingType) == "" ||
-				strings.Contains(recordIDs, rf.ID) ||
-				!strings.Contains(allowedTypes, string(rf.RecordingType)) {
-				continue
-			}
+	remoteFile, err := httpio.Get(
+		rec.DownloadURL,
+		httpio.WithClient(z.cli),
+		httpio.WithChunkSize(1024*1024*z.config.ChunckSizeMB),
+		httpio.WithConcurrency(z.config.Concurrency),
+		httpio.WithHeader("Authorization", fmt.Sprintf("Bearer %s", z.token.AccessToken)),
+	)
+	if err != nil {
+		z.logger.Printf("error fetching data: %v", err)
+		return nil, err
+	}
 
-			log.Printf("Downloading '%s' from %v of type %s", meeting.Topic, rf.RecordingStart, rf.RecordingType)
-			filePath, err := z.DownloadVideo(meeting.Topic, rf)
+	return remoteFile, nil
+}
+
+// getRange downloads the recording file from the offset with a range request
+// and returns the offset the body starts at, zoom sends the whole file when
+// it doesn't match the etag of the partial download anymore. A download
+// without the etag starts over since it can't be checked
+func (z *ZoomClient) getRange(rec RecordingFile, offset int64, partial *PartialDownload) (io.ReadCloser, int64, error) {
+	if offset > 0 && partial.ETag == "" {
+		z.logger.Printf("unable to resume %s without an etag, starting over", rec.ID)
+		offset = 0
+	}
+
+	if offset == 0 {
+		// the chunked download doesn't return the headers, the etag is
+		// taken before the download so a changed file is never resumed.
+		// The chunked download needs the head request as well, without it
+		// the file is downloaded in a single request
+		res, err := z.do(http.MethodHead, rec.DownloadURL, nil)
+		if err == nil {
+			res.Body.Close() //nolint: errcheck
+			partial.ETag = res.Header.Get("ETag")
+
+			remoteFile, err := z.get(rec)
 			if err != nil {
-				errs = errors.Join(errs, err)
-				continue
+				return nil, 0, err
 			}
 
-			records.Records = append(records.Records, SavedRecord{
-				ID:         rf.ID,
-				SessionID:  meeting.UUID,
-				Path:       filePath,
-				SavedAt:    time.Now(),
-				RecordedAt: rf.RecordingStart,
-			})
+			return io.NopCloser(remoteFile), 0, nil
 		}
 
-	CLEANUP:
-		if z.config.DeleteAfter {
-			log.Printf("Deleting '%s' from %v", meeting.Topic, meeting.StartTime)
-			if err := z.DeleteRecording(meeting.ID); err != nil {
-				errs = errors.Join(errs, err)
-			}
-		}
+		z.logger.Printf("unable to get the etag of %s, downloading it in a single request: %v", rec.ID, err)
 	}
-	log.Print(`finished fetching recordings`)
 
-	return errs
-}
+	res, err := z.do(http.MethodGet, rec.DownloadURL, nil, func(req *http.Request) {
+		if offset == 0 {
+			return
+		}
 
-func (z *ZoomClient) saveRecords(ctx context.Context, records *RecordHolder) {
-	file, err := z.fs.Writer(ctx, SavedRecordFileName)
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if partial.ETag != "" {
+			req.Header.Set("If-Range", partial.ETag)
+		}
+	})
 	if err != nil {
-		log.Printf("error opening writer for saving file: %v", err)
+		return nil, 0, err
 	}
 
-	slices.SortFunc(records.Records, func(a, b SavedRecord) int {
-		return a.RecordedAt.Compare(b.RecordedAt)
-	})
+	partial.ETag = res.Header.Get("ETag")
 
-	err = json.NewEncoder(file).Encode(records)
-	if err != nil {
-		log.Printf("error encoding file: %v", err)
+	if res.StatusCode != http.StatusPartialContent {
+		return res.Body, 0, nil
 	}
 
-	err = file.Close()
-	if err != nil {
-		log.Printf("error closing file: %v", err)
-	}
+	return res.Body, offset, nil
+}
+
+// recordsFile returns the name of the saved records file of the account
+func (z *ZoomClient) recordsFile() string {
+	return cmp.Or(z.config.SavedRecordsFile, SavedRecordFileName)
 }
 
 func (z *ZoomClient) do(method, url string, body io.Reader, opts ...func(*http.Request)) (*http.Response, error) {
@@ -456,7 +642,7 @@ func (z *ZoomClient) do(method, url string, body io.Reader, opts ...func(*http.R
 
 	req, err := http.NewRequest(method, url, body)
 	if err != nil {
-		log.Print(`error creating request`)
+		z.logger.Print(`error creating request`)
 		return nil, err
 	}
 	req = req.WithContext(z.context)
@@ -469,11 +655,11 @@ func (z *ZoomClient) do(method, url string, body io.Reader, opts ...func(*http.R
 
 	res, err := z.cli.Do(req)
 	if err != nil {
-		log.Printf("error getting request: %s %s", req.Method, url)
+		z.logger.Printf("error getting request: %s %s", req.Method, url)
 		return nil, err
 	}
 
-	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusPartialContent {
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusPartialContent && res.StatusCode != http.StatusNoContent {
 		buff := &bytes.Buffer{}
 		buff.ReadFrom(res.Body) //nolint: errcheck
 		return nil, fmt.Errorf("failed request statuscode %d and body: %s", res.StatusCode, buff.String())
@@ -487,13 +673,3 @@ func serializPathString(s string) string {
 	s = strings.ReplaceAll(s, ":", " -")
 	return s
 }
-
-func getRecordMap(records []SavedRecord) string {
-	var s strings.Builder
-
-	for _, rec := range records {
-		s.WriteString(" " + rec.ID)
-	}
-
-	return s.String()
-}
@@ -1,10 +1,11 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"fmt"
 	"os"
 	"path"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -37,17 +38,66 @@ func TestListAllRecordings(t *testing.T) {
 	assert(t, len(meetings) == 15, "expect 15 recordings")
 }
 
+func TestListRecordingsPagination(t *testing.T) {
+	c, mock := SetupTestWithMock(t, "tmp_list_recordings_pagination")
+
+	c.config.StartingFromYear = 2024
+
+	// more than two pages of 300 recordings in a single month
+	start := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 650 {
+		m := createMeeting(mock.baseURL, "busy", 10000+i, start.Add(time.Duration(i)*time.Minute), RecordingTypeAudioOnly)
+		m.HostID = mock.users[0].ID
+		mock.meetings = append(mock.meetings, m)
+	}
+
+	meetings, err := c.ListAllRecordings(time.Time{})
+	if err != nil {
+		t.Fatalf("unexpected error listing recordings: %v", err)
+	}
+
+	ids := map[int]bool{}
+	for _, meeting := range meetings {
+		ids[meeting.ID] = true
+	}
+
+	for i := range 650 {
+		if !ids[10000+i] {
+			t.Fatalf("missing recording %d of a later page", 10000+i)
+		}
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	c := SetupTest(t, "tmp_list_users")
+
+	users, err := c.ListUsers()
+	assert(t, err == nil, "unexpected error listing users")
+	assert(t, len(users) == 4, "expect 4 users over all pages")
+	assert(t, slices.ContainsFunc(users, func(u User) bool { return u.Status == "inactive" }), "deactivated users must be listed")
+}
+
 func TestDownload(t *testing.T) {
 	dir := "tmp_test_download"
 	c := SetupTest(t, dir)
 
-	fpath, err := c.DownloadVideo("static", RecordingFile{
+	paths, err := c.config.Paths()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rf := RecordingFile{
+		ID:             "123",
 		RecordingType:  RecordingTypeActiveSpeaker,
 		RecordingStart: time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC),
 		FileExtension:  "MP4",
 		DownloadURL:    c.config.APIEndpoint.JoinPath("files/123").String(),
-	})
+	}
+
+	fpath, err := paths.Path(User{}, Meeting{Topic: "static"}, rf)
+	assert(t, err == nil, "error must be nil")
 
+	_, err = c.download(c.fs, fpath, rf, &PartialDownload{ID: rf.ID, Path: fpath})
 	assert(t, err == nil, "error must be nil")
 	assert(t,
 		path.Join("static/2018-01-01_00-00-00_active_speaker.mp4") == fpath,
@@ -61,108 +111,128 @@ func TestDownload(t *testing.T) {
 	}
 }
 
-func TestSweep(t *testing.T) {
-	dir := "tmp_test_sweep"
-	c := SetupTest(t, dir)
+func TestDownloadResume(t *testing.T) {
+	dir := "tmp_test_download_resume"
+	c, mock := SetupTestWithMock(t, dir)
 
-	c.config.RecordingTypes = []string{
-		string(RecordingTypeActiveSpeaker),
-		string(RecordingTypeGallery),
-		string(RecordingTypeGallery),
-		string(RecordingTypeSpeaker),
+	target := "static/resume.mp4"
+	rf := RecordingFile{
+		ID:          "resume",
+		FileSize:    16,
+		DownloadURL: c.config.APIEndpoint.JoinPath("files/resume").String(),
 	}
-	c.config.IgnoreTitles = []string{"ignore"}
-	c.config.StartingFromYear = 2022
 
-	if err := c.Sweep(); err != nil {
-		t.Fatalf("unexpected error during sweep: %v", err)
-	}
+	mock.interruptedFiles["resume"] = 6
+	partial := &PartialDownload{ID: rf.ID, Path: target}
 
-	assertFileExists(t, path.Join(dir, "static/2022-10-01_00-00-00_gallery_view.mp4"))
-	assertFileExists(t, path.Join(dir, "static/2022-10-01_00-00-00_active_speaker.mp4"))
-	assertFileExists(t, path.Join(dir, "static/2022-11-01_00-00-00_active_speaker.mp4"))
-	assertFileExists(t, path.Join(dir, "static/2022-11-01_00-00-00_gallery_view.mp4"))
-	assertFileExists(t, path.Join(dir, "static2/2023-01-01_00-00-00_active_speaker.mp4"))
-	assertFileNotExists(t, path.Join(dir, "ignore/2023-01-02_00-00-00_.mp4"))
-}
+	_, err := c.download(c.fs, target, rf, partial)
+	assert(t, err != nil, "interrupted download must return an error")
+	assert(t, partial.Offset == 6, "partial download must keep the written bytes")
+	assertFileNotExists(t, path.Join(dir, target))
 
-func TestDeleteRecording(t *testing.T) {
-	c := SetupTest(t, "tmp_test_delete")
+	// the chunked download sends its own ranges
+	mock.ranges = nil
 
-	assert(t, c.DeleteRecording(1001) == nil, "deletion doesnt return an error")
-	assert(t, c.DeleteRecording(1001) != nil, "deletion returns an error")
+	dl, err := c.download(c.fs, target, rf, partial)
+	if err != nil {
+		t.Fatalf("unexpected error resuming download: %v", err)
+	}
+
+	assert(t, dl.size == 16, "size must be the size of the whole file")
+	assert(t, dl.sum == fmt.Sprintf("%x", sha256.Sum256([]byte("some random file"))), "hash must be the hash of the whole file")
+
+	content, err := os.ReadFile(path.Join(dir, target))
+	assert(t, err == nil && string(content) == "some random file", "resumed file must be complete")
+	assert(t, len(mock.ranges) == 1 && mock.ranges[0] == "bytes=6-", "download must resume from the written bytes")
 }
 
-func TestSaveRecords(t *testing.T) {
-	dir := "tmp_test_save"
-	c := SetupTest(t, dir)
+func TestDownloadResumeChanged(t *testing.T) {
+	dir := "tmp_test_download_resume_changed"
+	c, mock := SetupTestWithMock(t, dir)
 
-	ctx := context.Background()
-
-	c.saveRecords(ctx, &RecordHolder{
-		Records: []SavedRecord{
-			{
-				ID:         "random_id2",
-				SessionID:  "random_session_id2",
-				SavedAt:    time.Now(),
-				RecordedAt: time.Date(2022, time.September, 9, 12, 34, 0, 0, time.Local),
-				Path:       "random/random2.mp4",
-			},
-			{
-				ID:         "random_id1",
-				SessionID:  "random_session_id1",
-				SavedAt:    time.Now(),
-				RecordedAt: time.Date(2022, time.January, 1, 12, 34, 0, 0, time.Local),
-				Path:       "random/random.mp4",
-			},
-			{
-				ID:         "random_id3",
-				SessionID:  "random_session_id3",
-				SavedAt:    time.Now(),
-				RecordedAt: time.Date(2022, time.December, 5, 12, 34, 0, 0, time.Local),
-				Path:       "random/random3.mp4",
-			},
-		},
-	})
-
-	rd, err := c.fs.Reader(ctx, SavedRecordFileName)
-	if err != nil {
-		t.Fatalf("unable to read savefile: %v", err)
+	target := "static/changed.mp4"
+	rf := RecordingFile{
+		ID:          "changed",
+		FileSize:    16,
+		DownloadURL: c.config.APIEndpoint.JoinPath("files/changed").String(),
 	}
 
-	records := &RecordHolder{}
-	err = json.NewDecoder(rd).Decode(records)
+	mock.interruptedFiles["changed"] = 6
+	partial := &PartialDownload{ID: rf.ID, Path: target}
+
+	_, err := c.download(c.fs, target, rf, partial)
+	assert(t, err != nil, "interrupted download must return an error")
+	assert(t, partial.ETag == `"v1"`, "interrupted download must keep the etag of the file", partial.ETag)
+
+	mock.contents["changed"] = "some other file!"
+	mock.etags["changed"] = `"v2"`
+
+	dl, err := c.download(c.fs, target, rf, partial)
 	if err != nil {
-		t.Fatalf("unable to marshal json: %v", err)
+		t.Fatalf("unexpected error resuming download: %v", err)
 	}
 
-	if e, a := 3, len(records.Records); e != a {
-		t.Fatalf("expected %d but got %d", e, a)
-	}
+	content, err := os.ReadFile(path.Join(dir, target))
+	assert(t, err == nil && string(content) == "some other file!", "changed file must be downloaded again", string(content))
+	assert(t, dl.sum == fmt.Sprintf("%x", sha256.Sum256([]byte("some other file!"))), "hash must be the hash of the changed file")
+	assert(t, partial.ETag == `"v2"`, "etag of the changed file must be kept", partial.ETag)
+}
 
-	if e, a := "random_id1", records.Records[0].ID; e != a {
-		t.Errorf("expected %s but got %s", e, a)
-	}
+func TestDownloadWithoutHead(t *testing.T) {
+	dir := "tmp_test_download_without_head"
+	c, mock := SetupTestWithMock(t, dir)
 
-	if e, a := "random_id2", records.Records[1].ID; e != a {
-		t.Errorf("expected %s but got %s", e, a)
+	target := "static/nohead.mp4"
+	rf := RecordingFile{
+		ID:          "nohead",
+		FileSize:    16,
+		DownloadURL: c.config.APIEndpoint.JoinPath("files/nohead").String(),
 	}
 
-	if e, a := "random_id3", records.Records[2].ID; e != a {
-		t.Errorf("expected %s but got %s", e, a)
+	mock.rejectHead = true
+	mock.interruptedFiles["nohead"] = 6
+	partial := &PartialDownload{ID: rf.ID, Path: target}
+
+	_, err := c.download(c.fs, target, rf, partial)
+	assert(t, err != nil, "interrupted download must return an error")
+	assert(t, partial.Offset == 6, "partial download must keep the written bytes")
+	assert(t, partial.ETag == `"v1"`, "download without a head request must keep the etag of the response", partial.ETag)
+
+	if _, err := c.download(c.fs, target, rf, partial); err != nil {
+		t.Fatalf("download must not fail without a head request: %v", err)
 	}
+
+	content, err := os.ReadFile(path.Join(dir, target))
+	assert(t, err == nil && string(content) == "some random file", "download without a head request must be complete", string(content))
+	assert(t, slices.Equal(mock.ranges, []string{"bytes=6-"}), "download without a head request must be resumed", strings.Join(mock.ranges, ", "))
 }
 
-func assertFileExists(t *testing.T, fpath string) {
-	_, err := os.Stat(fpath)
-	if err != nil {
-		t.Errorf("missing expected file %s", fpath)
+func TestDownloadSizeMismatch(t *testing.T) {
+	dir := "tmp_test_download_size_mismatch"
+	c := SetupTest(t, dir)
+
+	target := "static/mismatch.mp4"
+	rf := RecordingFile{
+		ID:          "mismatch",
+		FileSize:    20,
+		DownloadURL: c.config.APIEndpoint.JoinPath("files/mismatch").String(),
 	}
+
+	_, err := c.download(c.fs, target, rf, &PartialDownload{ID: rf.ID, Path: target})
+	assert(t, err != nil, "download with a different size must fail")
+
+	entries, err := os.ReadDir(path.Join(dir, "static"))
+	assert(t, err == nil && len(entries) == 0, "download with a different size must be removed")
 }
 
-func assertFileNotExists(t *testing.T, fpath string) {
-	_, err := os.Stat(fpath)
-	if !strings.HasSuffix(err.Error(), "no such file or directory") {
-		t.Errorf("unexpected file %s, err: %v", fpath, err)
-	}
+func TestDeleteRecording(t *testing.T) {
+	c, mock := SetupTestWithMock(t, "tmp_test_delete")
+
+	assert(t, c.DeleteRecording("1001") == nil, "deletion doesnt return an error")
+	assert(t, c.DeleteRecording("1001") != nil, "deletion returns an error")
+	assert(t, len(mock.trash) == 1 && mock.trash[0].ID == 1001, "meeting is moved to the trash by default")
+
+	c.config.DeleteAction = "delete"
+	assert(t, c.DeleteRecording("1002") == nil, "deletion doesnt return an error")
+	assert(t, len(mock.trash) == 1, "meeting is deleted permanently")
 }